@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/certs"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/notify"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/server"
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -19,25 +30,58 @@ import (
 const Version = "v1.1.0"
 
 var (
-	renewFlag bool
+	renewFlag  bool
+	statusFlag bool
+	daemonFlag bool
+	onlyFlag   string
+	labelFlags = labelsFlag{}
+	forceFlag  bool
 )
 
+// labelsFlag collects repeated key=value flags.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	var pairs []string
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labelsFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("label '%s' is not in key=value form", s)
+	}
+	l[k] = v
+	return nil
+}
+
 func init() {
 	flag.Usage = func() {
 		w := flag.CommandLine.Output()
-		_, _ = fmt.Fprintf(w, "\nVersion: %v\n\nUsage: %v [ -renew ] -config CONFIG_FILE\n\n",
-			Version, filepath.Base(os.Args[0]))
+		_, _ = fmt.Fprintf(w, "\nVersion: %v\n\nUsage: %v [ -renew | -status | -daemon ] [ -confirm ]\n"+
+			"         [ -only CONF_ID,... ] [ -label KEY=VALUE ]... [ -force ] -config CONFIG_FILE\n"+
+			"       %v -config CONFIG_FILE redeploy [ -key KEY_FILE ] CONF_ID\n"+
+			"       %v -config CONFIG_FILE prune [ -policy POLICY ]\n\n",
+			Version, filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
 
 	flag.StringVar(&config.ConfigFilePath, "config", "", "Config file")
 	flag.BoolVar(&renewFlag, "renew", false, "Renew existing certs only")
+	flag.BoolVar(&statusFlag, "status", false, "Print status of managed certs as JSON and exit")
+	flag.BoolVar(&daemonFlag, "daemon", false, "Keep running, issuing and renewing certs every daemonInterval")
+	flag.BoolVar(&certs.ConfirmReissue, "confirm", false, "Reissue certs whose config changed since issuance")
+	flag.StringVar(&onlyFlag, "only", "", "Comma separated IDs of the cert configs to operate on")
+	flag.Var(labelFlags, "label", "Operate on cert configs having this KEY=VALUE label, can be repeated")
+	flag.BoolVar(&forceFlag, "force", false, "Reissue certs selected with -only or -label regardless of their renewal window")
 
 	flag.Parse()
 
-	// TODO:
-	// Log line numbers?
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger := slog.New(log.NewRedactingHandler(slog.NewJSONHandler(os.Stdout, nil)))
 	slog.SetDefault(logger)
 }
 
@@ -48,26 +92,199 @@ func main() {
 	}
 
 	cfg := config.GetConfig()
+	if err := accounts.Init(cfg); err != nil {
+		log.Fatal("couldn't set up accounts", "error", err.Error())
+	}
+	log.AddSecrets(cfg.ApiToken)
 
-	err := file.CreateDirIfNotExists(cfg.DataDir, os.ModePerm)
+	err := log.Setup(log.Options{
+		File:       cfg.LogFile,
+		Level:      cfg.LogLevel,
+		Format:     cfg.LogFormat,
+		Source:     cfg.LogSource,
+		MaxSize:    cfg.LogMaxSize,
+		MaxAge:     cfg.LogMaxAge,
+		MaxBackups: cfg.LogMaxBackups,
+	})
+	if err != nil {
+		log.Fatal("couldn't set up logging", "error", err.Error())
+	}
+	log.SetDefaultAttrs("run_id", run.ID())
+	go reopenLogOnSignal()
+
+	if statusFlag {
+		printStatus()
+		return
+	}
+
+	err = file.CreateDirIfNotExists(cfg.DataDir, os.ModePerm)
 	if err != nil {
 		log.Fatal("couldn't create directory", "dir", cfg.DataDir, "error", err.Error())
 	}
 
-	metrics.Init()
+	if err = notify.Init(cfg); err != nil {
+		log.Fatal("couldn't set up notifications", "error", err.Error())
+	}
+
+	metrics.Init(cfg.MetricLabels)
+	metrics.RunInfo.WithLabelValues(run.ID()).Set(1)
+
+	sel := certs.Selector{Labels: labelFlags, Force: forceFlag}
+	for _, id := range strings.Split(onlyFlag, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if !certs.ConfExists(id) {
+			log.Fatal("no such cert config", "conf_id", id)
+		}
+		sel.ConfIDs = append(sel.ConfIDs, id)
+	}
+	if forceFlag && daemonFlag {
+		log.Fatal("-force can't be used with -daemon")
+	}
+	if forceFlag && sel.All() {
+		// reissuing every cert at once is rarely intended and burns quota
+		log.Fatal("-force requires selecting certs with -only or -label")
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "redeploy":
+		redeploy(flag.Args()[1:])
+		notify.Wait()
+		return
+	case "prune":
+		prune(flag.Args()[1:])
+		return
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
 
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
+		server.RegisterHealth(http.DefaultServeMux)
 		addr := fmt.Sprintf(":%d", cfg.MetricsPort)
-		log.Info(fmt.Sprintf("Starting metrics server at %s", addr))
+		log.Info("starting metrics server", "addr", addr)
 		if err := http.ListenAndServe(addr, nil); err != nil {
-			log.Info(fmt.Sprintf("Error starting metrics server: %v", err))
+			log.Error("error starting metrics server", "addr", addr, "error", err.Error())
 		}
 	}()
 
+	if daemonFlag {
+		runDaemon(cfg, sel)
+		notify.Wait()
+		return
+	}
 	if renewFlag {
-		certs.Renew()
+		certs.CheckDrift(sel)
+		certs.Renew(sel)
 	} else {
-		certs.IssueCerts()
+		certs.CheckDrift(sel)
+		certs.IssueCerts(sel)
+	}
+	if sel.All() {
+		certs.HandleOrphans()
+	}
+	certs.SendDigestIfDue()
+	notify.Wait()
+}
+
+// runDaemon issues and renews certs every daemonInterval, serving the
+// management API when configured. It returns once SIGINT or SIGTERM was
+// received, after aborting pending retries and waits of the current run.
+func runDaemon(cfg *config.Config, sel certs.Selector) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	run.SetContext(ctx)
+	go func() {
+		<-ctx.Done()
+		// a second signal kills the process
+		stop()
+		log.Info("shutting down")
+	}()
+	if cfg.ApiListen != "" {
+		if err := server.StartApi(cfg); err != nil {
+			log.Fatal("couldn't start management API", "error", err.Error())
+		}
+	}
+	interval := time.Duration(cfg.DaemonInterval) * time.Minute
+	log.Info("running as daemon", "interval", interval)
+	for {
+		certs.CheckDrift(sel)
+		certs.IssueCerts(sel)
+		if sel.All() && !run.Stopping() {
+			certs.HandleOrphans()
+		}
+		if !run.Stopping() {
+			certs.SendDigestIfDue()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		newRun()
+	}
+}
+
+// newRun gives the next daemon iteration its own run ID in logs, hooks,
+// events and metrics.
+func newRun() {
+	id := run.New()
+	log.SetDefaultAttrs("run_id", id)
+	metrics.RunInfo.Reset()
+	metrics.RunInfo.WithLabelValues(id).Set(1)
+}
+
+// reopenLogOnSignal reopens the log file on SIGUSR1 so it can be rotated by
+// external tools.
+func reopenLogOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	for range sigs {
+		if err := log.Reopen(); err != nil {
+			log.Error("couldn't reopen log file", "error", err.Error())
+			continue
+		}
+		log.Info("log file reopened")
+	}
+}
+
+func printStatus() {
+	statuses, err := certs.Status()
+	if err != nil {
+		log.Fatal("couldn't get certs status", "error", err.Error())
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(statuses); err != nil {
+		log.Fatal("couldn't print certs status", "error", err.Error())
+	}
+}
+
+func redeploy(args []string) {
+	fs := flag.NewFlagSet("redeploy", flag.ExitOnError)
+	keyFile := fs.String("key", "", "Private key of the cert, the archived one when empty")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := certs.Redeploy(fs.Arg(0), *keyFile); err != nil {
+		log.Fatal("couldn't redeploy cert", "conf_id", fs.Arg(0), "error", err.Error())
+	}
+}
+
+func prune(args []string) {
+	policy := string(config.GetConfig().OrphanPolicy)
+	if policy == string(config.OrphanKeep) {
+		policy = string(config.OrphanPrune)
+	}
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	fs.StringVar(&policy, "policy", policy, "One of prune, revoke-and-prune or cancel, orphanPolicy by default")
+	_ = fs.Parse(args)
+	if err := certs.PruneOrphans(config.OrphanPolicy(policy)); err != nil {
+		log.Fatal("couldn't prune orphaned certs", "policy", policy, "error", err.Error())
 	}
 }
@@ -0,0 +1,345 @@
+// Package accounts manages the ZeroSSL accounts cert configs are issued
+// with, one client per account.
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/utils"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
+)
+
+// defaultApiUrl is the ZeroSSL REST API base.
+const defaultApiUrl = "https://api.zerossl.com"
+
+// Account is a ZeroSSL account shared by the cert configs referencing it.
+type Account struct {
+	Name string
+	// Quota is the number of certs the account may hold, 0 for no limit.
+	Quota  int
+	apiKey string
+	// apiUrl is the API base URL, without a trailing slash.
+	apiUrl  string
+	limiter *rateLimiter
+	breaker *breaker
+	// once makes every call a single attempt, see [Account.Once].
+	once bool
+}
+
+var accounts = map[string]*Account{}
+
+// Init creates the accounts of cfg. Cert configs with their own apiKey get
+// an implicit account named after them, see [Name].
+func Init(cfg *config.Config) error {
+	for name, conf := range cfg.Accounts {
+		key, err := apiKey(conf)
+		if err != nil {
+			return fmt.Errorf("account '%s': %w", name, err)
+		}
+		apiUrl, err := baseUrl(conf.ApiUrl)
+		if err != nil {
+			return fmt.Errorf("account '%s': %w", name, err)
+		}
+		add(cfg, name, key, apiUrl, conf.RateLimit, conf.Quota)
+	}
+	for _, c := range cfg.CertConfigs {
+		switch {
+		case c.Account != "" && c.ApiKey != "":
+			return fmt.Errorf("cert config '%s' has both account and apiKey", c.ConfID)
+		case c.Account != "":
+			if _, ok := accounts[c.Account]; !ok {
+				return fmt.Errorf("cert config '%s' references unknown account '%s'", c.ConfID, c.Account)
+			}
+		case c.ApiKey != "":
+			if _, ok := accounts[Name(&c)]; ok {
+				return fmt.Errorf("cert config '%s' has its own apiKey, but account '%s' is defined too",
+					c.ConfID, Name(&c))
+			}
+			add(cfg, Name(&c), c.ApiKey, defaultApiUrl, 0, 0)
+		default:
+			return fmt.Errorf("cert config '%s' has neither account nor apiKey", c.ConfID)
+		}
+	}
+	if cfg.OrphanAccount != "" {
+		if _, ok := accounts[cfg.OrphanAccount]; !ok {
+			return fmt.Errorf("orphanAccount references unknown account '%s'", cfg.OrphanAccount)
+		}
+	}
+	return nil
+}
+
+func add(cfg *config.Config, name, key, apiUrl string, rateLimit, quota int) {
+	log.AddSecrets(key)
+	accounts[name] = &Account{
+		Name:    name,
+		Quota:   quota,
+		apiKey:  key,
+		apiUrl:  apiUrl,
+		limiter: newRateLimiter(rateLimit),
+		breaker: newBreaker(name, cfg.BreakerThreshold, time.Duration(cfg.BreakerCoolDown)*time.Second),
+	}
+}
+
+// baseUrl checks the configured API base URL, defaultApiUrl when empty.
+func baseUrl(apiUrl string) (string, error) {
+	if apiUrl == "" {
+		return defaultApiUrl, nil
+	}
+	u, err := url.Parse(apiUrl)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("apiUrl '%s' isn't an absolute http(s) URL", apiUrl)
+	}
+	return strings.TrimSuffix(apiUrl, "/"), nil
+}
+
+// apiKey reads the key of an account from the configured source.
+func apiKey(conf config.AccountConf) (string, error) {
+	var key string
+	sources := 0
+	if conf.ApiKey != "" {
+		key = conf.ApiKey
+		sources++
+	}
+	if conf.ApiKeyFile != "" {
+		content, err := os.ReadFile(conf.ApiKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read api key file: %w", err)
+		}
+		key = strings.TrimSpace(string(content))
+		sources++
+	}
+	if conf.ApiKeyEnv != "" {
+		key = os.Getenv(conf.ApiKeyEnv)
+		if key == "" {
+			return "", fmt.Errorf("environment variable '%s' is empty", conf.ApiKeyEnv)
+		}
+		sources++
+	}
+	if sources != 1 {
+		return "", fmt.Errorf("exactly one of apiKey, apiKeyFile and apiKeyEnv must be set")
+	}
+	if key == "" {
+		return "", fmt.Errorf("api key is empty")
+	}
+	return key, nil
+}
+
+// Name returns the name of the account conf is issued with.
+func Name(conf *config.CertConf) string {
+	if conf.Account != "" {
+		return conf.Account
+	}
+	return "conf-" + conf.ConfID
+}
+
+// Get returns the account called name.
+func Get(name string) (*Account, error) {
+	a, ok := accounts[name]
+	if !ok {
+		return nil, fmt.Errorf("no account '%s'", name)
+	}
+	return a, nil
+}
+
+// ForConf returns the account conf is issued with.
+func ForConf(conf *config.CertConf) (*Account, error) {
+	return Get(Name(conf))
+}
+
+// Names returns the names of all accounts, sorted.
+func Names() []string {
+	names := make([]string, 0, len(accounts))
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// before is called ahead of every API request made with the account.
+func (a *Account) before() {
+	a.limiter.wait()
+	metrics.ApiRequests.WithLabelValues(a.Name).Inc()
+}
+
+// retry runs attempt with the configured retry policy of op, through the
+// circuit breaker of the account.
+func (a *Account) retry(op string, attempt func() error) error {
+	policy := utils.NewRetryPolicy(config.GetConfig().RetryFor(op))
+	if a.once {
+		policy.MaxAttempts = 1
+	}
+	logger := log.With("account", a.Name, "operation", op)
+	return policy.Do(run.Context(), logger, func() error {
+		if !a.breaker.allow() {
+			return &ApiError{Op: op, Class: ClassCircuitOpen, Err: ErrCircuitOpen}
+		}
+		err := attempt()
+		a.breaker.record(err)
+		var apiErr *ApiError
+		if errors.As(err, &apiErr) && apiErr.Class == ClassRateLimited && apiErr.RetryAfter() > 0 {
+			a.limiter.holdOff(apiErr.RetryAfter())
+		}
+		if err != nil && !a.breaker.available() {
+			// don't wait out the cool-down retrying
+			return &ApiError{Op: op, Class: ClassCircuitOpen, Err: fmt.Errorf("%w, last error: %v", ErrCircuitOpen, err)}
+		}
+		return err
+	})
+}
+
+// Once returns the account making every call a single attempt, for callers
+// retrying the calls with a policy of their own. Limits and the circuit
+// breaker still apply.
+func (a *Account) Once() *Account {
+	once := *a
+	once.once = true
+	return &once
+}
+
+// ProbeNow ends the cool-down of the open circuit breaker of the account,
+// so the next call goes through as its trial call. It is meant for work
+// that can't wait, such as certs about to expire.
+func (a *Account) ProbeNow() {
+	a.breaker.endCoolDown()
+}
+
+// Available reports whether the API of the account is considered up, i.e.
+// its circuit breaker isn't open.
+func (a *Account) Available() bool {
+	return a.breaker.available()
+}
+
+func (a *Account) CreateCert(commonName, csr, days, strictDomains string) (zerosslIPCert.CertificateInfoModel, error) {
+	var certInfo zerosslIPCert.CertificateInfoModel
+	form := url.Values{}
+	form.Set("certificate_domains", commonName)
+	form.Set("certificate_csr", csr)
+	form.Set("certificate_validity_days", days)
+	form.Set("strict_domains", strictDomains)
+	err := a.post(OpCreateCert, "/certificates", form, &certInfo)
+	return certInfo, err
+}
+
+func (a *Account) VerifyDomains(certID, method, email string) (zerosslIPCert.VerificationResultModel, error) {
+	var result zerosslIPCert.VerificationResultModel
+	form := url.Values{}
+	form.Set("validation_method", method)
+	if email != "" {
+		form.Set("validation_email", email)
+	}
+	err := a.post(OpVerifyDomains, certPath(certID, "challenges"), form, &result)
+	return result, err
+}
+
+func (a *Account) GetCert(certID string) (zerosslIPCert.CertificateInfoModel, error) {
+	var certInfo zerosslIPCert.CertificateInfoModel
+	err := a.get(OpGetCert, certPath(certID, ""), nil, &certInfo)
+	return certInfo, err
+}
+
+func (a *Account) DownloadCertInline(certID, includeCrossSigned string) (zerosslIPCert.CertificateContentModel, error) {
+	var content zerosslIPCert.CertificateContentModel
+	query := url.Values{}
+	query.Set("include_cross_signed", includeCrossSigned)
+	err := a.get(OpDownloadCert, certPath(certID, "download/return"), query, &content)
+	return content, err
+}
+
+// certPath returns the path of a cert endpoint, the cert itself when action
+// is empty.
+func certPath(certID, action string) string {
+	path := "/certificates/" + url.PathEscape(certID)
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
+
+// listPageSize is the number of certs fetched per page, the API maximum.
+const listPageSize = 100
+
+// unfinishedCert is a draft or pending cert.
+type unfinishedCert struct {
+	ID         string `json:"id"`
+	CommonName string `json:"common_name"`
+}
+
+// ListUnfinished returns the IDs of the draft and pending certs of the
+// account for commonName.
+func (a *Account) ListUnfinished(commonName string) ([]string, error) {
+	unfinished, err := a.listUnfinished(OpListCerts, commonName)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, c := range unfinished {
+		if c.CommonName == commonName {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids, nil
+}
+
+// listUnfinished lists the draft and pending certs of the account matching
+// search, all of them when it is empty, going through every page of
+// results.
+func (a *Account) listUnfinished(op, search string) ([]unfinishedCert, error) {
+	var unfinished []unfinishedCert
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("certificate_status", "draft,pending_validation")
+		if search != "" {
+			query.Set("search", search)
+		}
+		query.Set("limit", strconv.Itoa(listPageSize))
+		query.Set("page", strconv.Itoa(page))
+		var result struct {
+			TotalCount int              `json:"total_count"`
+			Results    []unfinishedCert `json:"results"`
+		}
+		if err := a.get(op, "/certificates", query, &result); err != nil {
+			return nil, err
+		}
+		unfinished = append(unfinished, result.Results...)
+		if len(result.Results) < listPageSize || page*listPageSize >= result.TotalCount {
+			return unfinished, nil
+		}
+	}
+}
+
+// CleanUnfinished cancels every draft and pending cert of the account.
+func (a *Account) CleanUnfinished() error {
+	unfinished, err := a.listUnfinished(OpCleanUnfinished, "")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, c := range unfinished {
+		if err = a.Cancel(c.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Revoke revokes an issued cert.
+func (a *Account) Revoke(certID string) error {
+	return a.post(OpRevokeCert, certPath(certID, "revoke"), nil, nil)
+}
+
+// Cancel cancels a cert that isn't issued yet.
+func (a *Account) Cancel(certID string) error {
+	return a.post(OpCancelCert, certPath(certID, "cancel"), nil, nil)
+}
@@ -0,0 +1,97 @@
+package accounts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+)
+
+// apiHttpClient makes the API requests of every account, with a transport
+// of its own.
+var apiHttpClient = &http.Client{
+	Transport: http.DefaultTransport.(*http.Transport).Clone(),
+	Timeout:   60 * time.Second,
+}
+
+// responseInfo is what error handling needs from an API response.
+type responseInfo struct {
+	status     int
+	retryAfter time.Duration
+}
+
+type apiResult struct {
+	Success any `json:"success"`
+	Error   *struct {
+		Code int    `json:"code"`
+		Type string `json:"type"`
+	} `json:"error"`
+}
+
+// post calls a ZeroSSL endpoint of the account API with the retry policy
+// of op, sending form and decoding the response into out when not nil.
+func (a *Account) post(op, path string, form url.Values, out any) error {
+	return a.retry(op, func() error {
+		return a.requestOnce(op, http.MethodPost, path, nil, form, out)
+	})
+}
+
+// get is like post for GET endpoints.
+func (a *Account) get(op, path string, query url.Values, out any) error {
+	return a.retry(op, func() error {
+		return a.requestOnce(op, http.MethodGet, path, query, nil, out)
+	})
+}
+
+func (a *Account) requestOnce(op, method, path string, query, form url.Values, out any) error {
+	a.before()
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("access_key", a.apiKey)
+	endpoint := fmt.Sprintf("%s%s?%s", a.apiUrl, path, query.Encode())
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(run.Context(), method, endpoint, body)
+	if err != nil {
+		return newApiError(op, err, responseInfo{}, 0, "")
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rsp, err := apiHttpClient.Do(req)
+	if err != nil {
+		return newApiError(op, err, responseInfo{}, 0, "")
+	}
+	defer rsp.Body.Close()
+	info := responseInfo{status: rsp.StatusCode, retryAfter: parseRetryAfter(rsp.Header.Get("Retry-After"))}
+	content, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return newApiError(op, err, info, 0, "")
+	}
+	var result apiResult
+	if err = json.Unmarshal(content, &result); err != nil {
+		return newApiError(op, fmt.Errorf("unexpected response from %s: status %d", path, rsp.StatusCode),
+			info, 0, "")
+	}
+	if result.Error != nil {
+		return newApiError(op, fmt.Errorf("api error %d: %s", result.Error.Code, result.Error.Type),
+			info, result.Error.Code, result.Error.Type)
+	}
+	if rsp.StatusCode >= 300 {
+		return newApiError(op, fmt.Errorf("unexpected status %d from %s", rsp.StatusCode, path), info, 0, "")
+	}
+	if out != nil {
+		if err = json.Unmarshal(content, out); err != nil {
+			return newApiError(op, fmt.Errorf("unexpected response from %s: %w", path, err), info, 0, "")
+		}
+	}
+	return nil
+}
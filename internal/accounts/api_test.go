@@ -0,0 +1,78 @@
+package accounts
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+)
+
+func testAccount(t *testing.T, handler http.HandlerFunc) *Account {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	config.ConfigFilePath = filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config.ConfigFilePath, []byte("retryMaxAttempts: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	apiUrl, err := baseUrl(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Account{Name: "test", apiKey: "key", apiUrl: apiUrl, limiter: newRateLimiter(0),
+		breaker: newBreaker("test", 5, 0)}
+}
+
+func TestAccountApiUrl(t *testing.T) {
+	a := testAccount(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/certificates":
+			_ = r.ParseForm()
+			_, _ = w.Write([]byte(`{"id":"abc","common_name":"` + r.PostForm.Get("certificate_domains") +
+				`","status":"draft"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/certificates/abc":
+			_, _ = w.Write([]byte(`{"id":"abc","common_name":"192.0.2.1","status":"issued"}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":false,"error":{"code":2832,"type":"certificate_not_found"}}`))
+		}
+	})
+
+	created, err := a.CreateCert("192.0.2.1", "csr", "90", "1")
+	if err != nil || created.ID != "abc" || created.CommonName != "192.0.2.1" {
+		t.Fatalf("CreateCert() = %+v, %v", created, err)
+	}
+	info, err := a.GetCert("abc")
+	if err != nil || info.Status != "issued" {
+		t.Fatalf("GetCert() = %+v, %v", info, err)
+	}
+	_, err = a.GetCert("missing")
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) || apiErr.Class != ClassNotFound {
+		t.Fatalf("GetCert() of a missing cert = %v, want a not_found error", err)
+	}
+}
+
+func TestBaseUrl(t *testing.T) {
+	for apiUrl, want := range map[string]string{
+		"":                              defaultApiUrl,
+		"https://zerossl.example.com/":  "https://zerossl.example.com",
+		"http://127.0.0.1:8080/zerossl": "http://127.0.0.1:8080/zerossl",
+	} {
+		if got, err := baseUrl(apiUrl); err != nil || got != want {
+			t.Errorf("baseUrl(%q) = %q, %v, want %q", apiUrl, got, err, want)
+		}
+	}
+	for _, apiUrl := range []string{"api.zerossl.com", "ftp://api.zerossl.com", "https://"} {
+		if _, err := baseUrl(apiUrl); err == nil {
+			t.Errorf("baseUrl(%q) accepted", apiUrl)
+		}
+	}
+}
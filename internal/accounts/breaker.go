@@ -0,0 +1,140 @@
+package accounts
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// ErrCircuitOpen is returned instead of calling the API while the circuit
+// breaker of an account is open.
+var ErrCircuitOpen = errors.New("circuit breaker open, ZeroSSL API considered unavailable")
+
+// Circuit breaker states, as exported by the circuit_breaker_state metric.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker stops calling the API of an account after consecutive transient
+// failures. Once the cool-down is over a single trial call decides whether
+// it closes again, other calls are refused until it is recorded. Rate
+// limiting isn't an outage, the Retry-After of the API is honoured
+// instead, see [rateLimiter.holdOff].
+type breaker struct {
+	mu        sync.Mutex
+	account   string
+	threshold int
+	coolDown  time.Duration
+	state     int
+	failures  int
+	openedAt  time.Time
+	// probing is set while the trial call of the half-open state runs.
+	probing bool
+}
+
+// newBreaker returns a breaker opening after threshold failures, or nil,
+// never opening, when threshold isn't positive.
+func newBreaker(account string, threshold int, coolDown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	b := &breaker{account: account, threshold: threshold, coolDown: coolDown}
+	b.setState(breakerClosed)
+	return b
+}
+
+// allow reports whether a call may go through, letting a single trial call
+// pass once the cool-down is over. A call allowed must be recorded.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+	default:
+		if time.Since(b.openedAt) < b.coolDown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+	}
+	b.probing = true
+	return true
+}
+
+// available reports whether calls would go through right now.
+func (b *breaker) available() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		return !b.probing
+	}
+	return time.Since(b.openedAt) >= b.coolDown
+}
+
+// endCoolDown lets the next call through as the trial call of an open
+// breaker without waiting for the rest of the cool-down.
+func (b *breaker) endCoolDown() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen {
+		b.openedAt = time.Time{}
+	}
+}
+
+// record updates the breaker with the outcome of a call. Only outages count
+// as failures, an error about the request itself shows the API is up.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Class {
+		case ClassTransient, ClassNetwork:
+			b.failures++
+			if b.state == breakerHalfOpen || b.failures >= b.threshold {
+				if b.state != breakerOpen {
+					log.Warn("ZeroSSL API unavailable, opening circuit breaker", "account", b.account,
+						"failures", b.failures, "cool_down", b.coolDown)
+				}
+				b.setState(breakerOpen)
+				b.openedAt = time.Now()
+			}
+			return
+		}
+	}
+	b.failures = 0
+	if b.state != breakerClosed {
+		log.Info("ZeroSSL API available again, closing circuit breaker", "account", b.account)
+		b.setState(breakerClosed)
+	}
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	metrics.CircuitBreakerState.WithLabelValues(b.account).Set(float64(state))
+}
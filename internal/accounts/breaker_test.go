@@ -0,0 +1,90 @@
+package accounts
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func outage() error {
+	return &ApiError{Op: OpGetCert, Class: ClassTransient, Err: errors.New("unexpected status 503")}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	b := newBreaker("test", 2, 10*time.Millisecond)
+	for range 2 {
+		if !b.allow() {
+			t.Fatal("closed breaker refused a call")
+		}
+		b.record(outage())
+	}
+	if b.allow() {
+		t.Fatal("open breaker allowed a call during the cool-down")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("no trial call allowed after the cool-down")
+	}
+	if b.allow() || b.available() {
+		t.Fatal("second call allowed while the trial call runs")
+	}
+	b.record(outage())
+	if b.allow() {
+		t.Fatal("failed trial call didn't open the breaker again")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("no trial call allowed after the second cool-down")
+	}
+	b.record(nil)
+	if !b.allow() || !b.allow() {
+		t.Fatal("successful trial call didn't close the breaker")
+	}
+}
+
+func TestBreakerIgnoresRateLimiting(t *testing.T) {
+	b := newBreaker("test", 1, time.Hour)
+	b.allow()
+	b.record(&ApiError{Op: OpGetCert, Class: ClassRateLimited, Err: errors.New("unexpected status 429"),
+		retryAfter: time.Minute})
+	if !b.available() {
+		t.Fatal("rate limiting opened the breaker")
+	}
+}
+
+func TestRateLimiterHoldOff(t *testing.T) {
+	l := newRateLimiter(0)
+	l.holdOff(30 * time.Millisecond)
+	start := time.Now()
+	l.wait()
+	if waited := time.Since(start); waited < 25*time.Millisecond {
+		t.Fatalf("waited %v, want the Retry-After of 30ms", waited)
+	}
+	start = time.Now()
+	l.wait()
+	if waited := time.Since(start); waited > 10*time.Millisecond {
+		t.Fatalf("waited %v after the hold off ended", waited)
+	}
+}
+
+func TestBreakerEndCoolDown(t *testing.T) {
+	b := newBreaker("test", 1, time.Hour)
+	b.allow()
+	b.record(outage())
+	if b.allow() {
+		t.Fatal("open breaker allowed a call during the cool-down")
+	}
+	b.endCoolDown()
+	if !b.allow() {
+		t.Fatal("no trial call allowed after ending the cool-down")
+	}
+	if b.allow() {
+		t.Fatal("second call allowed while the trial call runs")
+	}
+	b.record(outage())
+	if b.available() {
+		t.Fatal("failed trial call didn't restart the cool-down")
+	}
+}
@@ -0,0 +1,202 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// ErrorClass tells what kind of failure an [ApiError] is.
+type ErrorClass string
+
+const (
+	ClassAuth        ErrorClass = "auth"
+	ClassQuota       ErrorClass = "quota"
+	ClassNotFound    ErrorClass = "not_found"
+	ClassValidation  ErrorClass = "validation"
+	ClassRateLimited ErrorClass = "rate_limited"
+	ClassTransient   ErrorClass = "transient"
+	ClassNetwork     ErrorClass = "network"
+	ClassCircuitOpen ErrorClass = "circuit_open"
+	ClassUnknown     ErrorClass = "unknown"
+)
+
+// API operations, as used in errors and metrics.
+const (
+	OpCreateCert      = "create_cert"
+	OpVerifyDomains   = "verify_domains"
+	OpGetCert         = "get_cert"
+	OpDownloadCert    = "download_cert"
+	OpCleanUnfinished = "clean_unfinished"
+	OpRevokeCert      = "revoke_cert"
+	OpCancelCert      = "cancel_cert"
+	OpListCerts       = "list_certs"
+)
+
+// errorCodes maps the error codes ZeroSSL shares with the other APILayer
+// APIs to classes. Other codes differ by endpoint, their types are used.
+var errorCodes = map[int]ErrorClass{
+	101: ClassAuth,       // missing or invalid access key
+	102: ClassAuth,       // inactive user
+	103: ClassValidation, // invalid API function
+	104: ClassQuota,      // usage limit reached
+}
+
+// errorTypes maps ZeroSSL error types to classes.
+var errorTypes = map[string]ErrorClass{
+	"invalid_access_key":               ClassAuth,
+	"missing_access_key":               ClassAuth,
+	"inactive_user":                    ClassAuth,
+	"permission_denied":                ClassAuth,
+	"usage_limit_reached":              ClassQuota,
+	"certificate_limit_reached":        ClassQuota,
+	"rate_limit_reached":               ClassRateLimited,
+	"too_many_requests":                ClassRateLimited,
+	"certificate_not_found":            ClassNotFound,
+	"invalid_api_function":             ClassValidation,
+	"incorrect_request_type":           ClassValidation,
+	"invalid_csr":                      ClassValidation,
+	"domain_control_validation_failed": ClassValidation,
+	"internal_error":                   ClassTransient,
+	"service_unavailable":              ClassTransient,
+}
+
+// rateLimitPhrases are checked in error messages lacking a known type.
+var rateLimitPhrases = []string{"too many requests", "rate limit exceeded"}
+
+// ApiError is a failed ZeroSSL API call.
+type ApiError struct {
+	Op    string
+	Class ErrorClass
+	// Status is the HTTP status of the response, 0 when unknown.
+	Status int
+	// Code and Type are the ZeroSSL error, when the response had one.
+	Code       int
+	Type       string
+	Err        error
+	retryAfter time.Duration
+}
+
+func (e *ApiError) Error() string {
+	return log.Redact(fmt.Sprintf("%s failed (%s): %v", e.Op, e.Class, e.Err))
+}
+
+func (e *ApiError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent reports whether retrying the call can't help, an open circuit
+// breaker included.
+func (e *ApiError) Permanent() bool {
+	switch e.Class {
+	case ClassAuth, ClassQuota, ClassNotFound, ClassValidation, ClassCircuitOpen:
+		return true
+	}
+	return false
+}
+
+// RetryAfter is how long the API asked to wait before retrying, 0 when it
+// didn't say.
+func (e *ApiError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// newApiError classifies err returned by op and counts it.
+func newApiError(op string, err error, rsp responseInfo, code int, errType string) *ApiError {
+	e := &ApiError{
+		Op:         op,
+		Status:     rsp.status,
+		Code:       code,
+		Type:       errType,
+		Err:        err,
+		retryAfter: rsp.retryAfter,
+	}
+	e.Class = classify(err, rsp.status, code, errType)
+	// The URL of a failed request carries the API key, keep only the cause.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		e.Err = fmt.Errorf("%s %s: %w", urlErr.Op, redactedUrl(urlErr.URL), urlErr.Err)
+	}
+	metrics.ApiErrors.WithLabelValues(op, string(e.Class)).Inc()
+	return e
+}
+
+// classify tells the class of err from the ZeroSSL error type and code,
+// then the HTTP status. Only errors of the client library, which hides
+// both, fall back to looking for known error types in the message.
+func classify(err error, status int, code int, errType string) ErrorClass {
+	if class, ok := errorTypes[errType]; ok {
+		return class
+	}
+	if class, ok := errorCodes[code]; ok {
+		return class
+	}
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ClassRateLimited
+	case status >= 500:
+		return ClassTransient
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ClassAuth
+	case status == http.StatusNotFound:
+		return ClassNotFound
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return ClassNetwork
+	}
+	if status != 0 {
+		return ClassUnknown
+	}
+	text := strings.ToLower(err.Error())
+	for _, token := range strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_')
+	}) {
+		if class, ok := errorTypes[token]; ok {
+			return class
+		}
+	}
+	for _, phrase := range rateLimitPhrases {
+		if strings.Contains(text, phrase) {
+			return ClassRateLimited
+		}
+	}
+	return ClassUnknown
+}
+
+// redactedUrl strips the query, holding the API key, from rawUrl.
+func redactedUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "<invalid url>"
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or a date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
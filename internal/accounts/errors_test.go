@@ -0,0 +1,46 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    int
+		errType string
+		want    ErrorClass
+	}{
+		{"api type", errors.New("api error 0: invalid_access_key"), http.StatusOK, 0, "invalid_access_key", ClassAuth},
+		{"api code", errors.New("api error 104"), http.StatusOK, 104, "", ClassQuota},
+		{"type over status", errors.New("api error"), http.StatusBadRequest, 0, "certificate_not_found", ClassNotFound},
+		{"status 429", errors.New("unexpected status 429"), http.StatusTooManyRequests, 0, "", ClassRateLimited},
+		{"status 503", errors.New("unexpected status 503"), http.StatusServiceUnavailable, 0, "", ClassTransient},
+		{"status 403", errors.New("unexpected status 403"), http.StatusForbidden, 0, "", ClassAuth},
+		{"unknown status", errors.New("unexpected status 400"), http.StatusBadRequest, 0, "", ClassUnknown},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, 0, 0, "",
+			ClassNetwork},
+		{"library type in message", fmt.Errorf("request failed: {\"type\":\"certificate_not_found\"}"), 0, 0, "",
+			ClassNotFound},
+		{"library rate limit", errors.New("Too Many Requests"), 0, 0, "", ClassRateLimited},
+		// fragments of other words or URLs must not decide the class
+		{"access_key in url", errors.New("Get https://api.zerossl.com/certificates?access_key=x: bad gateway"),
+			0, 0, "", ClassUnknown},
+		{"verification word", errors.New("verification pending"), 0, 0, "", ClassUnknown},
+		{"maximum word", errors.New("maximum wait time exceeded"), 0, 0, "", ClassUnknown},
+		{"eof in word", errors.New("geofence rejected"), 0, 0, "", ClassUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.err, tt.status, tt.code, tt.errType); got != tt.want {
+				t.Errorf("classify() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
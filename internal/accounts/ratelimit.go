@@ -0,0 +1,45 @@
+package accounts
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces requests evenly to stay under a per minute limit, and
+// holds them off for as long as the API asked to when rate limiting.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter returns a limiter allowing perMinute requests a minute, any
+// number of them when perMinute isn't positive.
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// wait blocks until the next request is allowed.
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// holdOff delays every request until d from now.
+func (l *rateLimiter) holdOff(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); l.next.Before(until) {
+		l.next = until
+	}
+}
@@ -0,0 +1,116 @@
+package certs
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// Every issued cert and its key are archived in the data dir under
+// archive/<confId>/<certId>, so previous certs stay available to hooks
+// and for restoring. Only the archiveKeep most recent previous certs are
+// kept besides the current one, none when it is 0.
+const (
+	archiveCertName = "cert-fullchain.pem"
+	archiveKeyName  = "privkey.pem"
+)
+
+func archiveRoot() string {
+	return filepath.Join(config.GetConfig().DataDir, "archive")
+}
+
+func archiveDir(confID, certID string) string {
+	return filepath.Join(archiveRoot(), confID, certID)
+}
+
+// archivedCertPath returns the archived cert of certID, or an empty string
+// when it isn't archived.
+func archivedCertPath(confID, certID string) string {
+	if certID == "" {
+		return ""
+	}
+	path := filepath.Join(archiveDir(confID, certID), archiveCertName)
+	if !file.PathExists(path) {
+		return ""
+	}
+	return path
+}
+
+// archiveCert copies a cert and key pair into the archive of certID.
+func archiveCert(confID, certID, certFile, keyFile string) error {
+	dir := archiveDir(confID, certID)
+	if err := file.CreateDirIfNotExists(dir, 0o700); err != nil {
+		return err
+	}
+	// The archive holds private keys, tighten dirs created before as well.
+	for _, d := range []string{archiveRoot(), filepath.Dir(dir), dir} {
+		if err := os.Chmod(d, 0o700); err != nil {
+			return err
+		}
+	}
+	if err := file.CopyFile(certFile, filepath.Join(dir, archiveCertName), 0o700); err != nil {
+		return err
+	}
+	if err := file.CopyFile(keyFile, filepath.Join(dir, archiveKeyName), 0o700); err != nil {
+		return err
+	}
+	return os.Chmod(filepath.Join(dir, archiveKeyName), 0o600)
+}
+
+// pruneArchive removes the oldest archived certs of confID beyond
+// archiveKeep previous ones. The current cert is always kept, and the one
+// it replaced is the last previous cert removed.
+func pruneArchive(logger *log.Logger, confID, certID, prevCertID string) {
+	limit := *config.GetConfig().ArchiveKeep
+	if limit < 0 {
+		return
+	}
+	confDir := filepath.Join(archiveRoot(), confID)
+	entries, err := os.ReadDir(confDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("failed to list archived certs", "error", err.Error())
+		}
+		return
+	}
+	type archived struct {
+		certID  string
+		modTime time.Time
+	}
+	var older []archived
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == certID {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		older = append(older, archived{certID: e.Name(), modTime: info.ModTime()})
+	}
+	if len(older) <= limit {
+		return
+	}
+	// the replaced cert may have been archived only now, on its replacement
+	slices.SortFunc(older, func(a, b archived) int {
+		switch {
+		case a.certID == prevCertID:
+			return -1
+		case b.certID == prevCertID:
+			return 1
+		}
+		return b.modTime.Compare(a.modTime)
+	})
+	for _, c := range older[limit:] {
+		if err := os.RemoveAll(filepath.Join(confDir, c.certID)); err != nil {
+			logger.Warn("failed to remove archived cert", "cert_id", c.certID, "error", err.Error())
+			continue
+		}
+		logger.Info("removed archived cert", "cert_id", c.certID)
+	}
+}
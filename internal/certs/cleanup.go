@@ -0,0 +1,117 @@
+package certs
+
+import (
+	"errors"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
+)
+
+// cleanUnfinished cancels unfinished certs left behind by earlier attempts
+// for conf, as selected by cleanUnfinished. Failures are only logged, they
+// don't stop a new cert from being created.
+func cleanUnfinished(logger *log.Logger, conf *config.CertConf, account *accounts.Account) {
+	switch config.GetConfig().CleanUnfinished {
+	case config.CleanupOwn:
+		cancelOwnDrafts(logger, conf, account)
+	case config.CleanupCommonName:
+		cancelOwnDrafts(logger, conf, account)
+		ids, err := account.ListUnfinished(conf.CommonName)
+		if err != nil {
+			logger.Error("failed to list unfinished certs", "error", err.Error())
+			return
+		}
+		for _, id := range ids {
+			logger.Info("cancelling unfinished cert for common name", "unfinished_cert_id", id)
+			if err := account.Cancel(id); err != nil {
+				logger.Error("failed to cancel unfinished cert", "unfinished_cert_id", id, "error", err.Error())
+			}
+		}
+	case config.CleanupAccount:
+		logger.Warn("cancelling every unfinished cert of the account", "account", account.Name)
+		if err := account.CleanUnfinished(); err != nil {
+			logger.Error("failed to clean unfinished issuing certificate", "error", err.Error())
+		}
+	}
+}
+
+// cancelOwnDrafts cancels the drafts recorded for conf. A draft stays
+// recorded when cancelling it failed for a reason that may go away and it
+// still is a draft or pending.
+func cancelOwnDrafts(logger *log.Logger, conf *config.CertConf, account *accounts.Account) {
+	data := config.GetData()
+	var kept []config.DraftData
+	for _, d := range data.Drafts {
+		if d.ConfID != conf.ConfID {
+			kept = append(kept, d)
+			continue
+		}
+		draftAccount := account
+		if a, err := accounts.Get(d.Account); err == nil {
+			draftAccount = a
+		}
+		logger.Info("cancelling unfinished cert", "unfinished_cert_id", d.CertID, "account", draftAccount.Name)
+		err := draftAccount.Cancel(d.CertID)
+		var apiErr *accounts.ApiError
+		if err != nil && (!errors.As(err, &apiErr) ||
+			(apiErr.Class != accounts.ClassNotFound && apiErr.Class != accounts.ClassValidation)) &&
+			stillUnfinished(logger, draftAccount, d.CertID) {
+			logger.Error("failed to cancel unfinished cert", "unfinished_cert_id", d.CertID, "error", err.Error())
+			kept = append(kept, d)
+		}
+	}
+	if len(kept) == len(data.Drafts) {
+		return
+	}
+	data.Drafts = kept
+	if err := config.WriteData(data); err != nil {
+		logger.Error("failed to write data", "error", err.Error())
+	}
+}
+
+// stillUnfinished reports whether certID may still be cancelled, i.e. its
+// status is draft or pending validation, or can't be told. A draft issued
+// late, after local validation timed out, can't be cancelled anymore.
+func stillUnfinished(logger *log.Logger, account *accounts.Account, certID string) bool {
+	info, err := account.GetCert(certID)
+	if err != nil {
+		var apiErr *accounts.ApiError
+		return !errors.As(err, &apiErr) || apiErr.Class != accounts.ClassNotFound
+	}
+	if info.Status == certStatusDraft || info.Status == zerosslIPCert.CertStatus.PendingValidation {
+		return true
+	}
+	logger.Warn("forgetting unfinished cert that can't be cancelled anymore", "unfinished_cert_id", certID,
+		"status", info.Status)
+	return false
+}
+
+// recordDraft remembers a cert created for conf until it is issued.
+func recordDraft(logger *log.Logger, conf *config.CertConf, account *accounts.Account, certID string) {
+	data := config.GetData()
+	data.Drafts = append(data.Drafts, config.DraftData{
+		ConfID:     conf.ConfID,
+		CommonName: conf.CommonName,
+		CertID:     certID,
+		Account:    account.Name,
+	})
+	if err := config.WriteData(data); err != nil {
+		logger.Error("failed to write data", "error", err.Error())
+	}
+}
+
+// forgetDraft drops a recorded draft once it got issued.
+func forgetDraft(logger *log.Logger, certID string) {
+	data := config.GetData()
+	for i, d := range data.Drafts {
+		if d.CertID == certID {
+			data.Drafts = append(data.Drafts[:i], data.Drafts[i+1:]...)
+			if err := config.WriteData(data); err != nil {
+				logger.Error("failed to write data", "error", err.Error())
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package certs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/notify"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
+)
+
+const digestInterval = 24 * time.Hour
+
+// SendDigestIfDue sends the digest of all managed certs when the previous
+// one is older than a day. The last send time is kept in the data dir so
+// short lived runs don't send it more often. It is only updated once the
+// digest was delivered, so a failed delivery is retried on the next run.
+func SendDigestIfDue() {
+	if !notify.HasDigest() {
+		return
+	}
+	stampPath := filepath.Join(config.GetConfig().DataDir, "/last-digest")
+	if content, err := os.ReadFile(stampPath); err == nil {
+		last, err := time.Parse(time.RFC3339, strings.TrimSpace(string(content)))
+		if err == nil && time.Since(last) < digestInterval {
+			return
+		}
+	}
+	log.Info("sending certs digest")
+	if err := notify.SendDigest(digestEntries()); err != nil {
+		log.Error("digest not delivered, retrying on the next run", "error", err.Error())
+		return
+	}
+	if err := os.WriteFile(stampPath, []byte(time.Now().Format(time.RFC3339)), 0o644); err != nil {
+		log.Error("failed to write digest time", "file", stampPath, "error", err.Error())
+	}
+}
+
+// digestEntries describes every managed cert, asking ZeroSSL for its
+// status and falling back to the deployed file for the expiry.
+func digestEntries() []notify.DigestEntry {
+	opMu.Lock()
+	defer opMu.Unlock()
+	var entries []notify.DigestEntry
+	data := config.GetData()
+	for _, c := range config.GetConfig().CertConfigs {
+		entry := notify.DigestEntry{
+			ConfID:     c.ConfID,
+			CommonName: c.CommonName,
+			Status:     StateNotIssued,
+			Error:      getLastError(c.ConfID).msg,
+		}
+		i, err := findCertData(data, c.ConfID)
+		if err != nil {
+			entries = append(entries, entry)
+			continue
+		}
+		cert := data.Certs[i]
+		entry.CertID = cert.CertID
+		var certInfo zerosslIPCert.CertificateInfoModel
+		account, err := accounts.ForConf(&c)
+		if err == nil {
+			certInfo, err = account.GetCert(cert.CertID)
+		}
+		if err == nil {
+			entry.Status = certInfo.Status
+			if expires, err := time.Parse("2006-01-02 15:04:05", certInfo.Expires); err == nil {
+				entry.NotAfter = &expires
+			}
+		} else {
+			entry.Status = StateUnknown
+			if entry.Error == "" {
+				entry.Error = err.Error()
+			}
+		}
+		if entry.NotAfter == nil {
+			if leaf, err := readLeafCert(cert.CertFile); err == nil {
+				entry.NotAfter = &leaf.NotAfter
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
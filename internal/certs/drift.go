@@ -0,0 +1,208 @@
+package certs
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/hooks"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/notify"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// Kinds of drift between the deployed files and the data file.
+const (
+	driftCertMissing    = "cert_missing"
+	driftCertInvalid    = "cert_invalid"
+	driftKeyMissing     = "key_missing"
+	driftKeyMismatch    = "key_mismatch"
+	driftSerialMismatch = "serial_mismatch"
+)
+
+// Post hook events of redeployed certs.
+const (
+	eventRepaired   = "repaired"
+	eventRedeployed = "redeployed"
+)
+
+// CheckDrift makes sure the deployed cert and key of every issued cert
+// still are the ones recorded in the data file, and repairs them otherwise.
+func CheckDrift(sel Selector) {
+	opMu.Lock()
+	defer opMu.Unlock()
+	data := config.GetData()
+	for i := range data.Certs {
+		if run.Stopping() {
+			return
+		}
+		cert := data.Certs[i]
+		conf, err := findConf(cert.ConfID)
+		if err != nil || !sel.Matches(conf) {
+			continue
+		}
+		logger := certLogger(conf).With("cert_id", cert.CertID)
+		if cert.Serial == "" {
+			backfillSerial(logger, conf, &data.Certs[i])
+			cert = data.Certs[i]
+		}
+		kind := detectDrift(conf, &cert)
+		if kind == "" {
+			continue
+		}
+		logger.Warn("deployed cert drifted from data file", "drift", kind)
+		driftLabels := metrics.CertLabels(conf.ConfID, conf.Labels)
+		driftLabels["kind"] = kind
+		metrics.DriftDetected.With(driftLabels).Inc()
+		sendEvent(notify.EventDrift, conf, cert.CertID, nil, fmt.Errorf("deployed cert drifted: %s", kind))
+		err = repairDrift(logger, conf, cert)
+		recordResult(conf, err)
+		if err != nil {
+			logger.Error("failed to repair deployed cert", "error", err.Error())
+		}
+	}
+}
+
+// backfillSerial records the serial of certs issued before serials were
+// recorded, so they get the serial check too. The archived cert is
+// trusted over the deployed one, which may have drifted already.
+func backfillSerial(logger *log.Logger, conf *config.CertConf, cert *config.CertData) {
+	serial := ""
+	if archived := archivedCertPath(cert.ConfID, cert.CertID); archived != "" {
+		serial = deployedSerial(archived)
+	}
+	if serial == "" {
+		serial = deployedSerial(conf.CertFile)
+	}
+	if serial == "" {
+		return
+	}
+	cert.Serial = serial
+	logger.Info("backfilled serial of recorded cert", "serial", serial)
+	if err := config.WriteData(config.GetData()); err != nil {
+		logger.Error("failed to write data", "error", err.Error())
+	}
+}
+
+// detectDrift returns the kind of drift of the files deployed for conf from
+// the recorded cert, or an empty string when they are fine.
+func detectDrift(conf *config.CertConf, cert *config.CertData) string {
+	if !file.PathExists(conf.CertFile) {
+		return driftCertMissing
+	}
+	if !file.PathExists(conf.KeyFile) {
+		return driftKeyMissing
+	}
+	leaf, err := readLeafCert(conf.CertFile)
+	if err != nil {
+		return driftCertInvalid
+	}
+	if err = checkKeyMatches(leaf, conf.KeyFile); err != nil {
+		return driftKeyMismatch
+	}
+	if cert.Serial != "" && serialString(leaf) != cert.Serial {
+		return driftSerialMismatch
+	}
+	return ""
+}
+
+// repairDrift deploys the recorded cert again, downloading it and pairing
+// it with the deployed or archived key. Only when neither key fits is a new
+// cert issued, and when the config changed since issuance only with
+// -confirm.
+func repairDrift(logger *log.Logger, conf *config.CertConf, cert config.CertData) error {
+	keys := []string{conf.KeyFile, filepath.Join(archiveDir(cert.ConfID, cert.CertID), archiveKeyName)}
+	err := redeployCert(logger, conf, cert, keys, eventRepaired)
+	if err == nil {
+		return nil
+	}
+	if confChanged(conf, &cert) && !ConfirmReissue {
+		return fmt.Errorf("couldn't redeploy recorded cert and its config changed since issuance, "+
+			"run with -confirm to issue a new one: %w", err)
+	}
+	logger.Warn("couldn't redeploy recorded cert, issuing a new one", "error", err.Error())
+	return renewCert(logger, cert.CertID, conf, true)
+}
+
+// redeployCert downloads the recorded cert, pairs it with the first of keys
+// matching it and deploys both, running the post hook with event.
+func redeployCert(logger *log.Logger, conf *config.CertConf, cert config.CertData, keys []string, event string) error {
+	account, err := accounts.ForConf(conf)
+	if err != nil {
+		return err
+	}
+	cert_, err := account.DownloadCertInline(cert.CertID, "1")
+	if err != nil {
+		return fmt.Errorf("error downloading cert: %w", err)
+	}
+	var errs []string
+	for _, key := range keys {
+		if !file.PathExists(key) {
+			continue
+		}
+		fullChainPem, err := verifyDownloadedCert(conf, cert_.Certificate, cert_.CaBundle, key)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		return deployPair(logger, conf, cert.CertID, fullChainPem, key, event)
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no private key found for cert")
+	}
+	return fmt.Errorf("no usable private key for cert: %s", strings.Join(errs, "; "))
+}
+
+// deployPair writes the cert chain and copies the key to the configured
+// locations, each replaced atomically, archives them and runs the post hook.
+func deployPair(logger *log.Logger, conf *config.CertConf, certID, fullChainPem, keyPath, event string) error {
+	if err := file.CreateDirIfNotExists(filepath.Dir(conf.CertFile), os.ModePerm); err != nil {
+		return err
+	}
+	if keyPath != conf.KeyFile {
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			return fmt.Errorf("error reading private key file: %w", err)
+		}
+		if err = file.WriteFileAtomic(conf.KeyFile, key, 0o600); err != nil {
+			return fmt.Errorf("error writing private key file: %w", err)
+		}
+	}
+	if err := file.WriteFileAtomic(conf.CertFile, []byte(fullChainPem), 0o644); err != nil {
+		return fmt.Errorf("error writing cert file: %w", err)
+	}
+	if archivedCertPath(conf.ConfID, certID) == "" {
+		if err := archiveCert(conf.ConfID, certID, conf.CertFile, conf.KeyFile); err != nil {
+			logger.Warn("failed to archive cert", "error", err.Error())
+		}
+	}
+	logger.Info("cert deployed", "event", event)
+	if !conf.PostHook.IsSet() {
+		return nil
+	}
+	hookInfo, err := postHookInfo(conf, event, certID, "")
+	if err != nil {
+		return err
+	}
+	return hooks.RunPostHook(logger, conf, hookInfo)
+}
+
+// deployedSerial returns the serial of the deployed cert, empty when it
+// can't be read.
+func deployedSerial(certFile string) string {
+	leaf, err := readLeafCert(certFile)
+	if err != nil {
+		return ""
+	}
+	return serialString(leaf)
+}
+
+func serialString(c *x509.Certificate) string {
+	return strings.ToUpper(c.SerialNumber.Text(16))
+}
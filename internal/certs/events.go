@@ -0,0 +1,44 @@
+package certs
+
+import (
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/hooks"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/notify"
+)
+
+// sendEvent notifies about an event on the cert of conf. notAfter is read
+// from the deployed cert when not given.
+func sendEvent(eventType string, conf *config.CertConf, certID string, notAfter *time.Time, err error) {
+	e := notify.Event{
+		Type:       eventType,
+		ConfID:     conf.ConfID,
+		CommonName: conf.CommonName,
+		CertID:     certID,
+		NotAfter:   notAfter,
+		Labels:     conf.Labels,
+	}
+	if e.NotAfter == nil && eventType != notify.EventFailed {
+		if leaf, err := readLeafCert(conf.CertFile); err == nil {
+			e.NotAfter = &leaf.NotAfter
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	notify.Send(e)
+}
+
+// recordResult keeps the outcome of an operation on conf for the status and
+// notifies about and runs the failure hook on failures.
+func recordResult(conf *config.CertConf, err error) {
+	setLastError(conf.ConfID, err)
+	if err != nil {
+		sendEvent(notify.EventFailed, conf, "", nil, err)
+		logger := certLogger(conf)
+		if err := hooks.RunFailureHook(logger, conf, err); err != nil {
+			logger.Error("error running failure hook", "error", err.Error())
+		}
+	}
+}
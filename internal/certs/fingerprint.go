@@ -0,0 +1,114 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// ConfirmReissue allows reissuing certs whose config changed since they
+// were issued. Without it the change is only reported.
+var ConfirmReissue bool
+
+// issuanceParams are the cert config fields ending up in the cert, changing
+// any of them calls for a new cert.
+type issuanceParams struct {
+	Country          string `json:"country"`
+	Province         string `json:"province"`
+	City             string `json:"city"`
+	Locality         string `json:"locality"`
+	Organization     string `json:"organization"`
+	OrganizationUnit string `json:"organizationUnit"`
+	CommonName       string `json:"commonName"`
+	Days             int    `json:"days"`
+	KeyType          string `json:"keyType"`
+	KeyBits          int    `json:"keyBits"`
+	KeyCurve         string `json:"keyCurve"`
+	SigAlg           string `json:"sigAlg"`
+	StrictDomains    int    `json:"strictDomains"`
+}
+
+// confFingerprint hashes the issuance parameters of conf.
+func confFingerprint(conf *config.CertConf) string {
+	params, _ := json.Marshal(issuanceParams{
+		Country:          conf.Country,
+		Province:         conf.Province,
+		City:             conf.City,
+		Locality:         conf.Locality,
+		Organization:     conf.Organization,
+		OrganizationUnit: conf.OrganizationUnit,
+		CommonName:       conf.CommonName,
+		Days:             conf.Days,
+		KeyType:          conf.KeyType,
+		KeyBits:          conf.KeyBits,
+		KeyCurve:         conf.KeyCurve,
+		SigAlg:           conf.SigAlg,
+		StrictDomains:    conf.StrictDomains,
+	})
+	sum := sha256.Sum256(params)
+	return hex.EncodeToString(sum[:])
+}
+
+// confChanged reports whether conf changed since cert was issued. Certs
+// issued before fingerprints were recorded are compared with what the
+// deployed cert tells about its issuance parameters instead, and taken as
+// up to date when it can't be read.
+func confChanged(conf *config.CertConf, cert *config.CertData) bool {
+	if cert.ConfFingerprint != "" {
+		return cert.ConfFingerprint != confFingerprint(conf)
+	}
+	leaf, err := readLeafCert(cert.CertFile)
+	if err != nil {
+		return false
+	}
+	return !leafMatchesConf(conf, leaf)
+}
+
+// leafMatchesConf reports whether leaf carries the common name, key and
+// validity period conf asks for. The subject fields and signature
+// algorithm aren't compared, ZeroSSL doesn't keep them as requested.
+func leafMatchesConf(conf *config.CertConf, leaf *x509.Certificate) bool {
+	if ip := net.ParseIP(conf.CommonName); ip != nil {
+		if !slices.ContainsFunc(leaf.IPAddresses, ip.Equal) {
+			return false
+		}
+	} else if leaf.VerifyHostname(conf.CommonName) != nil {
+		return false
+	}
+	switch pub := leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if !strings.EqualFold(conf.KeyType, "rsa") || pub.N.BitLen() != conf.KeyBits {
+			return false
+		}
+	case *ecdsa.PublicKey:
+		if !strings.EqualFold(conf.KeyType, "ecdsa") || pub.Curve.Params().Name != conf.KeyCurve {
+			return false
+		}
+	}
+	days := leaf.NotAfter.Sub(leaf.NotBefore).Round(24*time.Hour) / (24 * time.Hour)
+	return conf.Days == 0 || int(days) == conf.Days
+}
+
+// renewOrReissue renews the cert, forcing a new one when its config changed
+// and reissuing is confirmed.
+func renewOrReissue(logger *log.Logger, cert *config.CertData, conf *config.CertConf, force bool) error {
+	if confChanged(conf, cert) {
+		if ConfirmReissue {
+			logger.Warn("cert config changed since issuance, reissuing")
+			force = true
+		} else {
+			logger.Warn("cert config changed since issuance, run with -confirm to reissue")
+		}
+	}
+	return renewCert(logger, cert.CertID, conf, force)
+}
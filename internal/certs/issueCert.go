@@ -1,69 +1,115 @@
 package certs
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509/pkix"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/hooks"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/notify"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/utils"
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
 	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
 )
 
-func IssueCerts() {
+// IssueCerts issues the certs selected by sel, renewing the ones that
+// already exist.
+func IssueCerts(sel Selector) {
+	opMu.Lock()
+	defer opMu.Unlock()
 	log.Info("Issuing certs")
 	for _, c := range config.GetConfig().CertConfigs {
-		log.Info(fmt.Sprintf("Issuing cert for domain: %v", c.CommonName))
-		err := issueCert(&c)
+		if run.Stopping() {
+			return
+		}
+		if !sel.Matches(&c) {
+			continue
+		}
+		logger := certLogger(&c)
+		if !sel.Force && Revoked(c.ConfID) {
+			logger.Warn("cert was revoked, not issuing a new one until forced")
+			continue
+		}
+		if postpone(logger, &c, sel) {
+			continue
+		}
+		logger.Info("issuing cert", "force", sel.Force)
+		err := issueCert(logger, &c, sel.Force)
+		recordResult(&c, err)
 		if err != nil {
-			log.Info(fmt.Sprintf("Failed to issue cert for domain %v: %v\n", c.CommonName, err))
+			logger.Error("failed to issue cert", "error", err.Error())
 		}
 	}
 }
 
-func issueCert(conf *config.CertConf) (err error) {
-	usingConfig := config.GetConfig()
+// certLogger returns a logger scoped to the cert config.
+func certLogger(conf *config.CertConf) *log.Logger {
+	return log.With("conf_id", conf.ConfID, "common_name", conf.CommonName)
+}
+
+func issueCert(logger *log.Logger, conf *config.CertConf, force bool) (err error) {
 	currentData := config.GetData()
-	for _, cert := range currentData.Certs {
+	for i, cert := range currentData.Certs {
 		if cert.ConfID == conf.ConfID {
-			log.Info("cert already exists, trying renew instead...", "domain", conf.CommonName)
-			err = renewCert(cert.CertID, conf)
+			logger.Info("cert already exists, trying renew instead...", "cert_id", cert.CertID)
+			err = renewOrReissue(logger.With("cert_id", cert.CertID), &currentData.Certs[i], conf, force)
 			return
 		}
 	}
-	log.Info(fmt.Sprintf("Cert for domain %v does not exist, try issue.", conf.CommonName))
-	client := &zerosslIPCert.Client{ApiKey: conf.ApiKey}
-	if usingConfig.CleanUnfinished {
-		if err := client.CleanUnfinished(); err != nil {
-			log.Error("failed to clean unfinished issuing certificate", "error", err.Error())
-		}
+	logger.Info("cert does not exist, try issue")
+	account, err := accounts.ForConf(conf)
+	if err != nil {
+		return err
+	}
+	if err = checkQuota(account); err != nil {
+		return err
 	}
-	certId, err := issueCertImpl(conf)
-	if err == nil {
-		log.Info("cert issued successfully", "domain", conf.CommonName)
-		metrics.CertsIssued.Inc()
+	cleanUnfinished(logger, conf, account)
+	certId, err := issueCertImpl(logger, conf, "")
+	if certId != "" {
+		if err == nil {
+			logger.Info("cert issued successfully", "cert_id", certId)
+			sendEvent(notify.EventIssued, conf, certId, nil, nil)
+		}
+		metrics.CertsIssued.With(metrics.CertLabels(conf.ConfID, conf.Labels)).Inc()
 		currentData.Certs = append(currentData.Certs, config.CertData{
-			CommonName: conf.CommonName,
-			CertID:     certId,
-			CertFile:   conf.CertFile,
-			KeyFile:    conf.KeyFile,
-			ConfID:     conf.ConfID,
+			CommonName:      conf.CommonName,
+			CertID:          certId,
+			Serial:          deployedSerial(conf.CertFile),
+			ConfFingerprint: confFingerprint(conf),
+			Account:         account.Name,
+			CertFile:        conf.CertFile,
+			KeyFile:         conf.KeyFile,
+			ConfID:          conf.ConfID,
 		})
-		if err = config.WriteData(currentData); err != nil {
-			log.Error("failed to write current data", "error", err.Error())
+		forgetRevoked(currentData, conf.ConfID)
+		forgetRolledBack(currentData, conf.ConfID)
+		if wErr := config.WriteData(currentData); wErr != nil {
+			logger.Error("failed to write current data", "error", wErr.Error())
+			if err == nil {
+				err = wErr
+			}
 		}
 	}
 	return
 }
 
-func issueCertImpl(conf *config.CertConf) (string, error) {
+// issueCertImpl issues and deploys a new cert for conf. prevCertID is the
+// cert being replaced on renewal, empty on first issuance. The returned
+// cert ID is set whenever the new cert is left deployed, even along with
+// an error when it isn't served but there was nothing to roll back to.
+func issueCertImpl(logger *log.Logger, conf *config.CertConf, prevCertID string) (string, error) {
 	tempDir, err := os.MkdirTemp(config.GetConfig().DataDir, "temp")
 	if err != nil {
 		return "", err
@@ -71,7 +117,10 @@ func issueCertImpl(conf *config.CertConf) (string, error) {
 	defer os.RemoveAll(tempDir)
 	tempPrivKeyPath := filepath.Join(tempDir, "/privkey.pem")
 	tempCertPath := filepath.Join(tempDir, "/cert-fullchain.pem")
-	client := &zerosslIPCert.Client{ApiKey: conf.ApiKey}
+	account, err := accounts.ForConf(conf)
+	if err != nil {
+		return "", err
+	}
 	privKey := zerosslIPCert.KeyGeneratorWrapper(conf.KeyType, conf.KeyBits, conf.KeyCurve)
 	subj := pkix.Name{
 		Country:            []string{conf.Country},
@@ -83,98 +132,202 @@ func issueCertImpl(conf *config.CertConf) (string, error) {
 	}
 	csr, err := zerosslIPCert.CSRGeneratorWrapper(conf.KeyType, subj, privKey, conf.SigAlg)
 	if err != nil {
-		log.Error("error generating csr", "error", err.Error())
+		logger.Error("error generating csr", "error", err.Error())
 		return "", err
 	}
 	csrStr_ := zerosslIPCert.GetCSRString(csr)
 	if csrStr_ == "" {
-		log.Info("failed to get csr string")
+		logger.Info("failed to get csr string")
 		return "", err
 	}
 	if err = zerosslIPCert.WritePrivKeyWrapper(conf.KeyType, privKey, tempPrivKeyPath); err != nil {
-		log.Error("error writing private key", "error", err.Error())
+		logger.Error("error writing private key", "error", err.Error())
+		return "", err
+	}
+	if err = hooks.RunPreHook(logger, conf); err != nil {
+		logger.Error("error running pre hook", "error", err.Error())
 		return "", err
 	}
-	log.Info("creating cert", "common_name", conf.CommonName)
-	certInfo, err := client.CreateCert(conf.CommonName, csrStr_, strconv.Itoa(conf.Days),
+	// Once the pre hook ran, the cleanup phase must run on every path, so
+	// whatever the hook opened gets closed even when no cert is created.
+	var certInfo *zerosslIPCert.CertificateInfoModel
+	cleanupValidation := sync.OnceFunc(func() {
+		if err := hooks.RunVerify(logger, conf, certInfo, hooks.PhaseCleanup); err != nil {
+			logger.Warn("error cleaning up validation", "error", err.Error())
+		}
+	})
+	defer cleanupValidation()
+	logger.Info("creating cert")
+	created, err := account.CreateCert(conf.CommonName, csrStr_, strconv.Itoa(conf.Days),
 		strconv.Itoa(conf.StrictDomains))
 	if err != nil {
-		log.Error("error creating cert", "error", err.Error())
+		logger.Error("error creating cert", "error", err.Error())
 		return "", err
 	}
-	if err = hooks.RunVerifyHook(conf.VerifyHook, &certInfo); err != nil {
-		log.Error("error running verify hook", "error", err.Error())
+	certInfo = &created
+	logger = logger.With("cert_id", certInfo.ID)
+	recordDraft(logger, conf, account, certInfo.ID)
+	err = validateCert(logger, account, conf, certInfo)
+	cleanupValidation()
+	if err != nil {
 		return "", err
 	}
-	if err = verifyHttpCsrHash(client, &certInfo); err != nil {
-		log.Error("verifying error", "error", err.Error())
+	forgetDraft(logger, certInfo.ID)
+	cert_, err := account.DownloadCertInline(certInfo.ID, "1")
+	if err != nil {
+		logger.Error("error downloading cert", "error", err.Error())
 		return "", err
 	}
-	cert_, err := client.DownloadCertInline(certInfo.ID, "1")
+	fullChainPem, err := verifyDownloadedCert(conf, cert_.Certificate, cert_.CaBundle, tempPrivKeyPath)
 	if err != nil {
-		log.Error("error downloading cert", "error", err.Error())
+		logger.Error("downloaded cert failed verification, keeping deployed files", "error", err.Error())
 		return "", err
 	}
-	fullChainPem := fmt.Sprintf("%s\n%s\n", strings.TrimSpace(cert_.Certificate), strings.TrimSpace(cert_.CaBundle))
 	tempCertFile, err := os.Create(tempCertPath)
 	if err != nil {
-		log.Error("error creating cert file", "error", err.Error())
+		logger.Error("error creating cert file", "error", err.Error())
 		return "", err
 	}
 	_, err = tempCertFile.WriteString(fullChainPem)
 	if err != nil {
-		log.Error("error writing to cert file", "error", err.Error())
+		logger.Error("error writing to cert file", "error", err.Error())
 		return "", err
 	}
+	if prevCertID != "" && archivedCertPath(conf.ConfID, prevCertID) == "" &&
+		file.PathExists(conf.CertFile) && file.PathExists(conf.KeyFile) {
+		if err = archiveCert(conf.ConfID, prevCertID, conf.CertFile, conf.KeyFile); err != nil {
+			logger.Warn("failed to archive previous cert", "error", err.Error())
+		}
+	}
+	if err = archiveCert(conf.ConfID, certInfo.ID, tempCertPath, tempPrivKeyPath); err != nil {
+		logger.Warn("failed to archive cert", "error", err.Error())
+	}
 	if err = file.CopyFile(tempCertPath, conf.CertFile, os.ModePerm); err != nil {
-		log.Error("error copying cert file", "error", err.Error())
+		logger.Error("error copying cert file", "error", err.Error())
 		return "", err
 	}
 	if err = file.CopyFile(tempPrivKeyPath, conf.KeyFile, os.ModePerm); err != nil {
-		log.Error("error copying private key file", "error", err.Error())
+		logger.Error("error copying private key file", "error", err.Error())
+		return "", err
+	}
+	event := notify.EventIssued
+	if prevCertID != "" {
+		event = notify.EventRenewed
+	}
+	hookInfo, err := postHookInfo(conf, event, certInfo.ID, prevCertID)
+	if err != nil {
+		logger.Error("error reading deployed cert", "error", err.Error())
 		return "", err
 	}
-	if err = hooks.RunPostHook(conf); err != nil {
-		log.Error("error running post hook", "error", err.Error())
+	if err = hooks.RunPostHook(logger, conf, hookInfo); err != nil {
+		logger.Error("error running post hook", "error", err.Error())
 		return "", err
 	}
+	if err = probeDeployedCert(logger, conf); err != nil {
+		logger.Error("deployed cert is not served", "error", err.Error())
+		if rbErr := rollbackCert(logger, conf, prevCertID); rbErr != nil {
+			// The new cert stays deployed, so it must be recorded, or every
+			// later run would issue yet another one.
+			logger.Error("failed to roll back cert, keeping the new one", "error", rbErr.Error())
+			return certInfo.ID, err
+		}
+		discardRolledBack(logger, account, conf, certInfo.ID)
+		return "", err
+	}
+	pruneArchive(logger, conf.ConfID, certInfo.ID, prevCertID)
 	return certInfo.ID, nil
 }
 
-func verifyHttpCsrHash(client *zerosslIPCert.Client, certInfo *zerosslIPCert.CertificateInfoModel) error {
-	cfg := config.GetConfig()
-	maxAttemps := cfg.RetryMaxAttempts
-	waitTime := time.Duration(cfg.RetryWaitTime) * time.Second
+// validateCert publishes the validation file and waits for the cert to be
+// issued. The caller runs the validation cleanup afterwards, whatever the
+// outcome.
+func validateCert(logger *log.Logger, account *accounts.Account, conf *config.CertConf,
+	certInfo *zerosslIPCert.CertificateInfoModel) error {
+	if err := hooks.RunVerify(logger, conf, certInfo, hooks.PhaseDeploy); err != nil {
+		logger.Error("error preparing validation", "error", err.Error())
+		return err
+	}
+	if err := preflightCheck(logger, conf, certInfo); err != nil {
+		logger.Error("validation url check failed", "error", err.Error())
+		return err
+	}
+	if err := verifyHttpCsrHash(logger, account, certInfo); err != nil {
+		logger.Error("verifying error", "error", err.Error())
+		return err
+	}
+	return nil
+}
+
+// postHookInfo describes certID, the cert deployed for conf, and prevCertID,
+// the one it replaced if any.
+func postHookInfo(conf *config.CertConf, event, certID, prevCertID string) (*hooks.PostHookInfo, error) {
+	leaf, err := readLeafCert(conf.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	sha256Sum := sha256.Sum256(leaf.Raw)
+	sha1Sum := sha1.Sum(leaf.Raw)
+	return &hooks.PostHookInfo{
+		Event:             event,
+		ConfID:            conf.ConfID,
+		CommonName:        conf.CommonName,
+		CertID:            certID,
+		CertFile:          conf.CertFile,
+		KeyFile:           conf.KeyFile,
+		Serial:            serialString(leaf),
+		Issuer:            leaf.Issuer.String(),
+		NotBefore:         leaf.NotBefore,
+		NotAfter:          leaf.NotAfter,
+		FingerprintSHA256: fingerprint(sha256Sum[:]),
+		FingerprintSHA1:   fingerprint(sha1Sum[:]),
+		PrevCertID:        prevCertID,
+		PrevCertFile:      archivedCertPath(conf.ConfID, prevCertID),
+		Labels:            conf.Labels,
+	}, nil
+}
+
+// fingerprint formats a digest the way openssl prints fingerprints.
+func fingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// opAwaitValidation names the retry policy for waiting until ZeroSSL picks
+// up the validation request.
+const opAwaitValidation = "await_validation"
 
-	for retrying := 0; retrying < maxAttemps; retrying++ {
-		verifyRsp, err := client.VerifyDomains(certInfo.ID, zerosslIPCert.VerifyDomainsMethod.HttpCsrHash, "")
+func verifyHttpCsrHash(logger *log.Logger, account *accounts.Account, certInfo *zerosslIPCert.CertificateInfoModel) error {
+	// the calls are retried by the await_validation policy alone
+	once := account.Once()
+	policy := utils.NewRetryPolicy(config.GetConfig().RetryFor(opAwaitValidation))
+	err := policy.Do(run.Context(), logger, func() error {
+		verifyRsp, err := once.VerifyDomains(certInfo.ID, zerosslIPCert.VerifyDomainsMethod.HttpCsrHash, "")
 		if err != nil {
-			log.Info(fmt.Sprintf("verify error: %v", err))
-			metrics.ApiErrors.Inc()
-			time.Sleep(waitTime)
-			waitTime = waitTime * 2
-			continue
+			return err
 		}
 		// NOTICE: ZeroSSL always return "Success:false" in HttpCsrHash verification.
-		log.Info(fmt.Sprintf("domains verification result: %+v", verifyRsp))
-		log.Info("retrieving certificate", "cert_id", certInfo.ID)
-		certInfoTmp, err := client.GetCert(certInfo.ID)
+		logger.Debug("domains verification result", "result", verifyRsp)
+		logger.Info("retrieving certificate")
+		certInfoTmp, err := once.GetCert(certInfo.ID)
 		if err != nil {
-			log.Info(fmt.Sprintf("get cert error: %v", err))
-			metrics.ApiErrors.Inc()
-			time.Sleep(waitTime)
-			waitTime = waitTime * 2
-			continue
+			return err
 		}
 		if certInfoTmp.Status != zerosslIPCert.CertStatus.PendingValidation &&
 			certInfoTmp.Status != zerosslIPCert.CertStatus.Issued {
-			log.Info(fmt.Sprintf("cert in %v status", certInfoTmp.Status))
-			time.Sleep(30 * time.Second)
-			continue
+			return fmt.Errorf("cert not pending validation yet, status '%s'", certInfoTmp.Status)
 		}
-		break
+		return nil
+	})
+	if utils.IsPermanent(err) {
+		return err
+	}
+	if err != nil {
+		logger.Warn("validation not confirmed, waiting for the cert anyway", "error", err.Error())
 	}
-	if err := WaitCertToBeReady(client, certInfo.ID); err != nil {
+	if err := WaitCertToBeReady(logger, account, certInfo.ID); err != nil {
 		return err
 	}
 	return nil
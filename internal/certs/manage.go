@@ -0,0 +1,193 @@
+package certs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+)
+
+// opMu serializes operations changing certs and their data, so on-demand
+// requests don't interleave with scheduled runs.
+var opMu sync.Mutex
+
+// ErrNotFound is returned for an unknown conf ID or a cert never issued.
+var ErrNotFound = errors.New("not found")
+
+// ErrRevoked is returned when renewing a cert revoked on demand without
+// forcing a reissue.
+var ErrRevoked = errors.New("revoked")
+
+func findConf(confID string) (*config.CertConf, error) {
+	for _, c := range config.GetConfig().CertConfigs {
+		if c.ConfID == confID {
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("no config with conf id '%s': %w", confID, ErrNotFound)
+}
+
+func findCertData(data *config.Data, confID string) (int, error) {
+	for i, c := range data.Certs {
+		if c.ConfID == confID {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no cert issued for conf id '%s': %w", confID, ErrNotFound)
+}
+
+// Revoked reports whether the cert of confID was revoked on demand and no
+// new one was forced since.
+func Revoked(confID string) bool {
+	return slices.Contains(config.GetData().Revoked, confID)
+}
+
+// forgetRevoked clears the revoked mark of confID once a cert is issued.
+func forgetRevoked(data *config.Data, confID string) {
+	data.Revoked = slices.DeleteFunc(data.Revoked, func(id string) bool { return id == confID })
+}
+
+// ConfExists reports whether a cert config with confID exists.
+func ConfExists(confID string) bool {
+	_, err := findConf(confID)
+	return err == nil
+}
+
+// RenewConf renews the cert of a single config, issuing it when it doesn't
+// exist yet. With force the renewal window is ignored, and a revoked cert
+// is issued again.
+func RenewConf(confID string, force bool) error {
+	opMu.Lock()
+	defer opMu.Unlock()
+	conf, err := findConf(confID)
+	if err != nil {
+		return err
+	}
+	if !force && Revoked(confID) {
+		return fmt.Errorf("cert of conf id '%s' was revoked: %w", confID, ErrRevoked)
+	}
+	logger := certLogger(conf)
+	logger.Info("renewing cert on demand", "force", force)
+	err = issueCert(logger, conf, force)
+	recordResult(conf, err)
+	return err
+}
+
+// Revoke revokes the cert of a config at ZeroSSL and forgets it, deployed
+// files are left in place. The config is marked revoked, so scheduled runs
+// don't issue a new cert until a reissue is forced.
+func Revoke(confID string) error {
+	opMu.Lock()
+	defer opMu.Unlock()
+	conf, err := findConf(confID)
+	if err != nil {
+		return err
+	}
+	data := config.GetData()
+	i, err := findCertData(data, confID)
+	if err != nil {
+		return err
+	}
+	cert := data.Certs[i]
+	logger := certLogger(conf).With("cert_id", cert.CertID)
+	logger.Info("revoking cert")
+	account, err := accounts.ForConf(conf)
+	if err != nil {
+		return err
+	}
+	if err = account.Revoke(cert.CertID); err != nil {
+		logger.Error("failed to revoke cert", "error", err.Error())
+		setLastError(confID, err)
+		return err
+	}
+	data.Certs = append(data.Certs[:i], data.Certs[i+1:]...)
+	if !slices.Contains(data.Revoked, confID) {
+		data.Revoked = append(data.Revoked, confID)
+	}
+	if err = config.WriteData(data); err != nil {
+		logger.Error("failed to write data", "error", err.Error())
+		return err
+	}
+	logger.Info("cert revoked")
+	setLastError(confID, nil)
+	return nil
+}
+
+// Redeploy deploys the already issued cert of a config again without
+// issuing a new one, e.g. on a rebuilt host. The private key is keyFile
+// when given, otherwise the archived or the deployed one.
+func Redeploy(confID, keyFile string) error {
+	opMu.Lock()
+	defer opMu.Unlock()
+	conf, err := findConf(confID)
+	if err != nil {
+		return err
+	}
+	data := config.GetData()
+	i, err := findCertData(data, confID)
+	if err != nil {
+		return err
+	}
+	cert := data.Certs[i]
+	logger := certLogger(conf).With("cert_id", cert.CertID)
+	logger.Info("redeploying cert")
+	keys := []string{filepath.Join(archiveDir(confID, cert.CertID), archiveKeyName), conf.KeyFile}
+	if keyFile != "" {
+		keys = []string{keyFile}
+	}
+	err = redeployCert(logger, conf, cert, keys, eventRedeployed)
+	recordResult(conf, err)
+	if err != nil {
+		return err
+	}
+	data.Certs[i].Serial = deployedSerial(conf.CertFile)
+	data.Certs[i].CertFile = conf.CertFile
+	data.Certs[i].KeyFile = conf.KeyFile
+	if err = config.WriteData(data); err != nil {
+		logger.Error("failed to write data", "error", err.Error())
+		return err
+	}
+	logger.Info("cert redeployed")
+	return nil
+}
+
+// CertPEM returns the deployed cert chain of a config.
+func CertPEM(confID string) ([]byte, error) {
+	data, err := config.LoadData(config.DataFilePath())
+	if err != nil {
+		return nil, err
+	}
+	i, err := findCertData(data, confID)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(data.Certs[i].CertFile)
+}
+
+// checkQuota fails when account holds as many certs as its quota allows.
+func checkQuota(account *accounts.Account) error {
+	if account.Quota <= 0 {
+		return nil
+	}
+	held := 0
+	for _, cert := range config.GetData().Certs {
+		name := cert.Account
+		if name == "" {
+			if conf, err := findConf(cert.ConfID); err == nil {
+				name = accounts.Name(conf)
+			}
+		}
+		if name == account.Name {
+			held++
+		}
+	}
+	if held >= account.Quota {
+		return fmt.Errorf("account '%s' reached its quota of %d certs", account.Name, account.Quota)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package certs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
+)
+
+// certStatusDraft is the ZeroSSL status of a cert created but not yet sent
+// to validation.
+const certStatusDraft = "draft"
+
+// HandleOrphans applies the configured orphan policy.
+func HandleOrphans() {
+	policy := config.GetConfig().OrphanPolicy
+	if policy == config.OrphanKeep {
+		return
+	}
+	if err := PruneOrphans(policy); err != nil {
+		log.Error("failed to handle orphaned certs", "policy", policy, "error", err.Error())
+	}
+}
+
+// PruneOrphans applies policy to every cert in the data file without a
+// config. Certs the policy fails for are kept, to be retried next time.
+func PruneOrphans(policy config.OrphanPolicy) error {
+	if !policy.Valid() {
+		return fmt.Errorf("unknown orphan policy '%s'", policy)
+	}
+	opMu.Lock()
+	defer opMu.Unlock()
+	data := config.GetData()
+	var kept []config.CertData
+	var failed int
+	for _, cert := range data.Certs {
+		if _, err := findConf(cert.ConfID); err == nil {
+			kept = append(kept, cert)
+			continue
+		}
+		logger := log.With("conf_id", cert.ConfID, "common_name", cert.CommonName, "cert_id", cert.CertID,
+			"policy", policy)
+		if policy == config.OrphanKeep {
+			logger.Warn("keeping cert without config")
+			kept = append(kept, cert)
+			continue
+		}
+		if err := handleOrphan(logger, policy, cert); err != nil {
+			logger.Error("failed to handle cert without config", "error", err.Error())
+			setLastError(cert.ConfID, err)
+			kept = append(kept, cert)
+			failed++
+			continue
+		}
+		setLastError(cert.ConfID, nil)
+		logger.Info("pruned cert without config")
+	}
+	if len(kept) != len(data.Certs) {
+		data.Certs = kept
+		if err := config.WriteData(data); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d orphaned certs couldn't be handled", failed)
+	}
+	return nil
+}
+
+func handleOrphan(logger *log.Logger, policy config.OrphanPolicy, cert config.CertData) error {
+	switch policy {
+	case config.OrphanCancel:
+		account, err := orphanAccount(cert)
+		if err != nil {
+			return err
+		}
+		// ZeroSSL only cancels certs that aren't issued yet. Issued ones may
+		// still be served, they are left alone at ZeroSSL and only dropped
+		// from the data file.
+		info, err := account.GetCert(cert.CertID)
+		if err != nil {
+			return err
+		}
+		switch info.Status {
+		case certStatusDraft, zerosslIPCert.CertStatus.PendingValidation:
+			logger.Info("cancelling cert", "account", account.Name)
+			return account.Cancel(cert.CertID)
+		case zerosslIPCert.CertStatus.Issued, zerosslIPCert.CertStatus.ExpiringSoon:
+			logger.Warn("cert is issued and can't be cancelled, forgetting it without revoking",
+				"status", info.Status)
+			return nil
+		}
+		logger.Info("cert is no longer active, nothing to cancel", "status", info.Status)
+	case config.OrphanRevokeAndPrune:
+		account, err := orphanAccount(cert)
+		if err != nil {
+			return err
+		}
+		logger.Info("revoking cert", "account", account.Name)
+		if err := account.Revoke(cert.CertID); err != nil {
+			return err
+		}
+		removeOrphanFiles(logger, cert)
+	}
+	return nil
+}
+
+// orphanAccount returns the account cert was issued with, or orphanAccount
+// when it isn't known.
+func orphanAccount(cert config.CertData) (*accounts.Account, error) {
+	if cert.Account != "" {
+		if account, err := accounts.Get(cert.Account); err == nil {
+			return account, nil
+		}
+	}
+	name := config.GetConfig().OrphanAccount
+	if name == "" {
+		return nil, fmt.Errorf("account of the cert is unknown and orphanAccount isn't set")
+	}
+	return accounts.Get(name)
+}
+
+// removeOrphanFiles removes the deployed files and the archive of cert.
+// The files are kept when a cert config still deploys to them, e.g. after
+// its conf ID was renamed, or when they hold another cert than the orphan.
+func removeOrphanFiles(logger *log.Logger, cert config.CertData) {
+	if err := os.RemoveAll(archiveDir(cert.ConfID, cert.CertID)); err != nil {
+		logger.Warn("failed to remove archive", "error", err.Error())
+	}
+	for _, c := range config.GetConfig().CertConfigs {
+		for _, path := range []string{cert.CertFile, cert.KeyFile} {
+			if path != "" && (samePath(path, c.CertFile) || samePath(path, c.KeyFile)) {
+				logger.Warn("keeping files still deployed to by a cert config", "file", path,
+					"deployed_by", c.ConfID)
+				return
+			}
+		}
+	}
+	if cert.Serial == "" || deployedSerial(cert.CertFile) != cert.Serial {
+		logger.Warn("keeping files not holding the orphaned cert", "cert_file", cert.CertFile,
+			"serial", cert.Serial)
+		return
+	}
+	for _, path := range []string{cert.CertFile, cert.KeyFile} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Warn("failed to remove file", "file", path, "error", err.Error())
+		}
+	}
+}
+
+// samePath reports whether a and b name the same file.
+func samePath(a, b string) bool {
+	if b == "" {
+		return false
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
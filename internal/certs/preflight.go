@@ -0,0 +1,118 @@
+package certs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/utils"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
+)
+
+const preflightAttempts = 3
+
+// preflightCheck fetches the validation URL the way ZeroSSL will and makes
+// sure it serves the expected content, so a misconfigured responder fails
+// fast instead of burning verification retries.
+func preflightCheck(logger *log.Logger, conf *config.CertConf, certInfo *zerosslIPCert.CertificateInfoModel) error {
+	pf := conf.Preflight
+	if pf == nil || !pf.Enabled {
+		return nil
+	}
+	v, ok := certInfo.Validation.OtherMethods[certInfo.CommonName]
+	if !ok {
+		return fmt.Errorf("preflight: no validation details for '%s'", certInfo.CommonName)
+	}
+	client, err := preflightClient(pf)
+	if err != nil {
+		return fmt.Errorf("preflight: %w", err)
+	}
+	logger.Info("checking validation url", "url", v.FileValidationUrlHttp, "address", pf.Address)
+	policy := utils.RetryPolicy{
+		MaxAttempts: preflightAttempts,
+		Base:        time.Duration(pf.RetryWaitTime) * time.Second,
+	}
+	err = policy.Do(run.Context(), logger, func() error {
+		return fetchValidationFile(client, v.FileValidationUrlHttp, v.FileValidationContent)
+	})
+	if err != nil {
+		return fmt.Errorf("preflight: %w", err)
+	}
+	logger.Info("validation url serves the expected content")
+	return nil
+}
+
+// preflightClient dials pf.Address instead of the URL host when set, and
+// binds to pf.SourceAddress, so the check can go through the same path as
+// external traffic.
+func preflightClient(pf *config.PreflightConf) (*http.Client, error) {
+	dialer := &net.Dialer{Timeout: time.Duration(pf.Timeout) * time.Second}
+	if pf.SourceAddress != "" {
+		ip := net.ParseIP(pf.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address '%s'", pf.SourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if pf.Address != "" {
+				addr = pf.Address
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		DisableKeepAlives: true,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(pf.Timeout) * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+func fetchValidationFile(client *http.Client, validationUrl string, expected []string) error {
+	rsp, err := client.Get(validationUrl)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && urlErr.Timeout() {
+			return fmt.Errorf("%s timed out, is port 80 reachable through the firewall?", validationUrl)
+		}
+		return fmt.Errorf("%s is unreachable, is the responder running and port 80 open? %w", validationUrl, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 300 && rsp.StatusCode < 400 {
+		return fmt.Errorf("%s redirects to '%s', the file must be served directly over http",
+			validationUrl, rsp.Header.Get("Location"))
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d, is the file published under the right path?",
+			validationUrl, rsp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, 64*1024))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", validationUrl, err)
+	}
+	got := strings.Split(strings.TrimSpace(strings.ReplaceAll(string(body), "\r\n", "\n")), "\n")
+	if len(got) != len(expected) {
+		return fmt.Errorf("%s serves %d lines, expected %d, is another file or an error page served?",
+			validationUrl, len(got), len(expected))
+	}
+	for i := range expected {
+		if strings.TrimSpace(got[i]) != strings.TrimSpace(expected[i]) {
+			return fmt.Errorf("%s content differs from the expected one at line %d, is a stale file served?",
+				validationUrl, i+1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/hooks"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/utils"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+const eventRollback = "rollback"
+
+// rollbackBackoff is how long no new cert is issued for a config after one
+// was rolled back, so a server not picking up new certs doesn't get a new
+// one issued on every run.
+const rollbackBackoff = 24 * time.Hour
+
+// probeDeployedCert makes a TLS handshake with the probe address of conf
+// and checks the served leaf is the deployed one.
+func probeDeployedCert(logger *log.Logger, conf *config.CertConf) error {
+	probe := conf.Probe
+	if probe == nil {
+		return nil
+	}
+	deployed, err := readLeafCert(conf.CertFile)
+	if err != nil {
+		return err
+	}
+	addr := probe.Address
+	if addr == "" {
+		addr = net.JoinHostPort(conf.CommonName, "443")
+	}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: time.Duration(probe.Timeout) * time.Second},
+		// the served cert is compared byte for byte, and an IP address gets
+		// no SNI, so there's nothing more to verify
+		Config: &tls.Config{InsecureSkipVerify: true},
+	}
+	logger.Info("probing deployed cert", "address", addr)
+	policy := utils.RetryPolicy{
+		MaxAttempts: probe.Retries + 1,
+		Base:        time.Duration(probe.RetryWaitTime) * time.Second,
+	}
+	err = policy.Do(run.Context(), logger, func() error {
+		conn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("tls handshake with %s failed: %w", addr, err)
+		}
+		defer conn.Close()
+		served := conn.(*tls.Conn).ConnectionState().PeerCertificates
+		if len(served) == 0 || !bytes.Equal(served[0].Raw, deployed.Raw) {
+			return fmt.Errorf("%s doesn't serve the deployed cert", addr)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("deployed cert is served", "address", addr)
+	return nil
+}
+
+// rollbackCert restores the archived cert and key of prevCertID in place of
+// the new cert and runs the post hook again.
+func rollbackCert(logger *log.Logger, conf *config.CertConf, prevCertID string) error {
+	prevCert := archivedCertPath(conf.ConfID, prevCertID)
+	if prevCert == "" {
+		return fmt.Errorf("no archived cert to roll back to")
+	}
+	logger.Warn("rolling back to previous cert", "prev_cert_id", prevCertID)
+	cert, err := os.ReadFile(prevCert)
+	if err != nil {
+		return err
+	}
+	key, err := os.ReadFile(filepath.Join(filepath.Dir(prevCert), archiveKeyName))
+	if err != nil {
+		return err
+	}
+	if err = file.WriteFileAtomic(conf.KeyFile, key, 0o600); err != nil {
+		return err
+	}
+	if err = file.WriteFileAtomic(conf.CertFile, cert, 0o644); err != nil {
+		return err
+	}
+	// the restored cert is the deployed one, the new cert is discarded
+	hookInfo, err := postHookInfo(conf, eventRollback, prevCertID, "")
+	if err != nil {
+		return err
+	}
+	return hooks.RunPostHook(logger, conf, hookInfo)
+}
+
+// discardRolledBack revokes a new cert that was rolled back, so it doesn't
+// hold a slot of the account quota unrecorded, and backs off issuing for
+// conf for rollbackBackoff.
+func discardRolledBack(logger *log.Logger, account *accounts.Account, conf *config.CertConf, certID string) {
+	if err := account.Revoke(certID); err != nil {
+		logger.Warn("failed to revoke rolled back cert", "error", err.Error())
+	} else {
+		logger.Info("revoked rolled back cert")
+		if err = os.RemoveAll(archiveDir(conf.ConfID, certID)); err != nil {
+			logger.Warn("failed to remove archived cert", "error", err.Error())
+		}
+	}
+	data := config.GetData()
+	forgetRolledBack(data, conf.ConfID)
+	data.RolledBack = append(data.RolledBack, config.RollbackData{
+		ConfID: conf.ConfID,
+		CertID: certID,
+		Time:   time.Now(),
+	})
+	if err := config.WriteData(data); err != nil {
+		logger.Error("failed to write data", "error", err.Error())
+	}
+}
+
+// rolledBackUntil returns until when issuing for confID backs off after a
+// new cert was rolled back, the zero time when it doesn't.
+func rolledBackUntil(confID string) time.Time {
+	for _, r := range config.GetData().RolledBack {
+		if r.ConfID == confID {
+			return r.Time.Add(rollbackBackoff)
+		}
+	}
+	return time.Time{}
+}
+
+// forgetRolledBack ends the back-off of confID once a cert is deployed.
+func forgetRolledBack(data *config.Data, confID string) {
+	data.RolledBack = slices.DeleteFunc(data.RolledBack, func(r config.RollbackData) bool { return r.ConfID == confID })
+}
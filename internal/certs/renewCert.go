@@ -1,86 +1,111 @@
 package certs
 
 import (
-	"fmt"
 	"time"
 
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
-	"github.com/alexkhomych/zerossl-ip-cert/internal/utils"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/notify"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
 	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
 )
 
-func Renew() {
+// renewBefore is how long before expiry a cert is due for renewal.
+const renewBefore = time.Hour * 24 * 29
+
+// Renew renews the existing certs selected by sel.
+func Renew(sel Selector) {
 	cfg := config.GetConfig()
+	opMu.Lock()
+	defer opMu.Unlock()
 	data := config.GetData()
 	log.Info("will renew current certs")
 loopRenew:
-	for _, cert := range data.Certs {
-		log.Info(fmt.Sprintf("try renewing cert: %v", cert.CommonName))
+	for i, cert := range data.Certs {
+		if run.Stopping() {
+			return
+		}
+		logger := log.With("conf_id", cert.ConfID, "common_name", cert.CommonName, "cert_id", cert.CertID)
 		for _, c := range cfg.CertConfigs {
 			if c.ConfID == cert.ConfID {
-				err := renewCert(cert.CertID, &c)
+				if !sel.Matches(&c) || postpone(logger, &c, sel) {
+					continue loopRenew
+				}
+				logger.Info("try renewing cert", "force", sel.Force)
+				err := renewOrReissue(logger, &data.Certs[i], &c, sel.Force)
+				recordResult(&c, err)
 				if err != nil {
-					log.Info(fmt.Sprintf("Failed to renew cert for domain %v: %v", c.CommonName, err))
+					logger.Error("failed to renew cert", "error", err.Error())
 				}
 				continue loopRenew
 			}
 		}
-		log.Error("no config for renewing cert", "domain", cert.CommonName)
+		if sel.All() {
+			logger.Error("no config for renewing cert", "orphan_policy", cfg.OrphanPolicy)
+		}
 	}
 }
 
-func renewCert(id string, conf *config.CertConf) error {
-	usingConfig := config.GetConfig()
+// renewCert reissues the cert when it is due for renewal, or regardless of
+// its expiry when force is set.
+func renewCert(logger *log.Logger, id string, conf *config.CertConf, force bool) error {
 	data := config.GetData()
-	log.Info("renewing cert", "domain", conf.CommonName)
-	client := &zerosslIPCert.Client{ApiKey: conf.ApiKey}
-
-	var certInfo zerosslIPCert.CertificateInfoModel
-	err := utils.RetryOperationWithConfig(func() error {
-		var err error
-		certInfo, err = client.GetCert(id)
+	logger.Info("renewing cert")
+	account, err := accounts.ForConf(conf)
+	if err != nil {
 		return err
-	})
+	}
+
+	certInfo, err := account.GetCert(id)
 	if err != nil {
-		log.Error("failed to get cert info", "error", err.Error())
-		metrics.ApiErrors.Inc()
+		logger.Error("failed to get cert info", "error", err.Error())
 		return err
 	}
 	expireTime_, err := time.Parse("2006-01-02 15:04:05", certInfo.Expires)
-	if err != nil {
-		log.Info(fmt.Sprintf("Failed to convert expiring time: %v", err))
+	if force {
+		logger.Info("forced renewal, ignoring expiry", "expires", certInfo.Expires)
+	} else if err != nil {
+		logger.Warn("failed to convert expiring time", "expires", certInfo.Expires, "error", err.Error())
 	} else {
 		if certInfo.Status != zerosslIPCert.CertStatus.ExpiringSoon &&
-			time.Now().Add(time.Hour*24*29).Before(expireTime_) {
-			log.Info(fmt.Sprintf("Cert %v is not due for renewal, skip renewing.", conf.CommonName))
+			time.Now().Add(renewBefore).Before(expireTime_) {
+			logger.Info("cert is not due for renewal, skip renewing", "expires", certInfo.Expires)
+			sendEvent(notify.EventSkipped, conf, id, &expireTime_, nil)
 			return nil
 		}
+		sendEvent(notify.EventExpiring, conf, id, &expireTime_, nil)
 	}
-	if usingConfig.CleanUnfinished {
-		if err := client.CleanUnfinished(); err != nil {
-			log.Error("failed to clean unfinished issuing certificate", "error", err.Error())
+	cleanUnfinished(logger, conf, account)
+	certId, err := issueCertImpl(logger, conf, id)
+	if certId != "" {
+		if err == nil {
+			logger.Info("cert renewed successfully", "new_cert_id", certId)
+			sendEvent(notify.EventRenewed, conf, certId, nil, nil)
 		}
-	}
-	certId, err := issueCertImpl(conf)
-	if err == nil {
-		log.Info("cert issued successfully", "domain", conf.CommonName)
-		metrics.CertsRenewed.Inc()
+		metrics.CertsRenewed.With(metrics.CertLabels(conf.ConfID, conf.Labels)).Inc()
 		for i, c := range data.Certs {
 			if c.CertID == id {
 				data.Certs[i].ConfID = conf.ConfID
 				data.Certs[i].CommonName = conf.CommonName
 				data.Certs[i].CertID = certId
+				data.Certs[i].Serial = deployedSerial(conf.CertFile)
+				data.Certs[i].ConfFingerprint = confFingerprint(conf)
+				data.Certs[i].Account = account.Name
 				data.Certs[i].CertFile = conf.CertFile
 				data.Certs[i].KeyFile = conf.KeyFile
 				break
 			}
 		}
-		if err = config.WriteData(data); err != nil {
-			log.Error("failed to write data", "error", err.Error())
+		forgetRolledBack(data, conf.ConfID)
+		if wErr := config.WriteData(data); wErr != nil {
+			logger.Error("failed to write data", "error", wErr.Error())
+			if err == nil {
+				err = wErr
+			}
 		}
 	}
 
-	return nil
+	return err
 }
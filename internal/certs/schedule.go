@@ -0,0 +1,45 @@
+package certs
+
+import (
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// postpone reports whether work on conf should wait because its last new
+// cert was rolled back recently, or because the circuit breaker of its
+// account is open. Certs expiring within breakerUrgent days or without a
+// readable deployed cert are never postponed for the breaker, their first
+// call is let through as the trial call of the breaker, so they keep
+// probing the API ahead of the others. Forced operations are never
+// postponed.
+func postpone(logger *log.Logger, conf *config.CertConf, sel Selector) bool {
+	if sel.Force {
+		return false
+	}
+	if until := rolledBackUntil(conf.ConfID); time.Now().Before(until) {
+		logger.Warn("last new cert was rolled back, postponing cert", "until", until)
+		return true
+	}
+	account, err := accounts.ForConf(conf)
+	if err != nil || account.Available() {
+		return false
+	}
+	leaf, err := readLeafCert(conf.CertFile)
+	if err != nil {
+		logger.Warn("ZeroSSL API unavailable, probing it for cert not deployed", "account", account.Name)
+		account.ProbeNow()
+		return false
+	}
+	urgent := time.Duration(config.GetConfig().BreakerUrgent) * 24 * time.Hour
+	if time.Until(leaf.NotAfter) < urgent {
+		logger.Warn("ZeroSSL API unavailable, probing it for urgent cert", "account", account.Name,
+			"not_after", leaf.NotAfter)
+		account.ProbeNow()
+		return false
+	}
+	logger.Warn("ZeroSSL API unavailable, postponing cert", "account", account.Name, "not_after", leaf.NotAfter)
+	return true
+}
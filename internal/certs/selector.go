@@ -0,0 +1,43 @@
+package certs
+
+import (
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+)
+
+// Selector narrows bulk operations down to some cert configs. The zero
+// value selects every config.
+type Selector struct {
+	// ConfIDs selects configs by ID.
+	ConfIDs []string
+	// Labels selects configs having all of the given labels.
+	Labels map[string]string
+	// Force ignores the renewal window of the selected certs.
+	Force bool
+}
+
+// All reports whether s selects every config.
+func (s Selector) All() bool {
+	return len(s.ConfIDs) == 0 && len(s.Labels) == 0
+}
+
+// Matches reports whether conf is selected.
+func (s Selector) Matches(conf *config.CertConf) bool {
+	if len(s.ConfIDs) > 0 {
+		found := false
+		for _, id := range s.ConfIDs {
+			if id == conf.ConfID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range s.Labels {
+		if lv, ok := conf.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+)
+
+// Cert states reported by [Status].
+const (
+	StateNotIssued = "not_issued"
+	StateValid     = "valid"
+	StateRenewable = "renewable"
+	StateExpired   = "expired"
+	StateOrphaned  = "orphaned"
+	StateUnknown   = "unknown"
+)
+
+// Next actions reported by [Status].
+const (
+	ActionNone  = "none"
+	ActionIssue = "issue"
+	ActionRenew = "renew"
+)
+
+// CertStatus describes the state of a single managed certificate.
+type CertStatus struct {
+	ConfID     string     `json:"confId"`
+	CommonName string     `json:"commonName"`
+	CertID     string     `json:"certId,omitempty"`
+	CertFile   string     `json:"certFile,omitempty"`
+	State      string     `json:"state"`
+	NotAfter   *time.Time `json:"notAfter,omitempty"`
+	NextAction string     `json:"nextAction"`
+	NextAt     *time.Time `json:"nextActionAt,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	// ErrorClass is the kind of LastError, such as an API error class.
+	ErrorClass string `json:"errorClass,omitempty"`
+	// ConfigChanged is set when the config changed since the cert was issued.
+	ConfigChanged bool `json:"configChanged,omitempty"`
+}
+
+// Error classes of failures other than API errors.
+const (
+	errorClassRevoked  = "revoked"
+	errorClassCertFile = "cert_file"
+	errorClassOther    = "other"
+)
+
+// lastError is the failure of the latest operation on a cert config.
+type lastError struct {
+	msg   string
+	class string
+}
+
+var (
+	lastErrorsMu sync.Mutex
+	lastErrors   = map[string]lastError{}
+)
+
+// setLastError records the outcome of the latest operation on a cert config,
+// a nil err clears the previous error.
+func setLastError(confID string, err error) {
+	lastErrorsMu.Lock()
+	defer lastErrorsMu.Unlock()
+	if err == nil {
+		delete(lastErrors, confID)
+		return
+	}
+	lastErrors[confID] = lastError{msg: err.Error(), class: errorClass(err)}
+}
+
+func getLastError(confID string) lastError {
+	lastErrorsMu.Lock()
+	defer lastErrorsMu.Unlock()
+	return lastErrors[confID]
+}
+
+// errorClass tells what kind of failure err is without revealing its text.
+func errorClass(err error) string {
+	var apiErr *accounts.ApiError
+	switch {
+	case errors.As(err, &apiErr):
+		return string(apiErr.Class)
+	case errors.Is(err, ErrRevoked):
+		return errorClassRevoked
+	default:
+		return errorClassOther
+	}
+}
+
+// Public returns s without the details that are only for operators: the
+// cert file path and the error text, which may include API responses and
+// file paths. The error class is kept.
+func (s CertStatus) Public() CertStatus {
+	s.CertFile = ""
+	s.LastError = ""
+	return s
+}
+
+// Status reports every configured cert as well as certs left in the data
+// file without a config. It reads the data file and the deployed cert files
+// only, no API calls are made.
+func Status() ([]CertStatus, error) {
+	cfg := config.GetConfig()
+	data, err := config.LoadData(config.DataFilePath())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var statuses []CertStatus
+	for _, c := range cfg.CertConfigs {
+		lastErr := getLastError(c.ConfID)
+		s := CertStatus{
+			ConfID:     c.ConfID,
+			CommonName: c.CommonName,
+			State:      StateNotIssued,
+			NextAction: ActionIssue,
+			NextAt:     &now,
+			LastError:  lastErr.msg,
+			ErrorClass: lastErr.class,
+		}
+		for _, cert := range data.Certs {
+			if cert.ConfID == c.ConfID {
+				s.CertID = cert.CertID
+				s.CertFile = cert.CertFile
+				fillExpiry(&s, now)
+				if confChanged(&c, &cert) {
+					s.ConfigChanged = true
+					// without -confirm the change is only reported
+					if ConfirmReissue {
+						s.NextAction = ActionRenew
+						s.NextAt = &now
+					}
+				}
+				break
+			}
+		}
+		statuses = append(statuses, s)
+	}
+loopOrphans:
+	for _, cert := range data.Certs {
+		for _, c := range cfg.CertConfigs {
+			if c.ConfID == cert.ConfID {
+				continue loopOrphans
+			}
+		}
+		lastErr := getLastError(cert.ConfID)
+		statuses = append(statuses, CertStatus{
+			ConfID:     cert.ConfID,
+			CommonName: cert.CommonName,
+			CertID:     cert.CertID,
+			CertFile:   cert.CertFile,
+			State:      StateOrphaned,
+			NextAction: ActionNone,
+			LastError:  lastErr.msg,
+			ErrorClass: lastErr.class,
+		})
+	}
+	return statuses, nil
+}
+
+func fillExpiry(s *CertStatus, now time.Time) {
+	leaf, err := readLeafCert(s.CertFile)
+	if err != nil {
+		s.State = StateUnknown
+		s.NextAction = ActionRenew
+		s.NextAt = &now
+		if s.LastError == "" {
+			s.LastError = err.Error()
+			s.ErrorClass = errorClassCertFile
+		}
+		return
+	}
+	notAfter := leaf.NotAfter
+	renewAt := notAfter.Add(-renewBefore)
+	s.NotAfter = &notAfter
+	s.NextAction = ActionRenew
+	switch {
+	case now.After(notAfter):
+		s.State = StateExpired
+		s.NextAt = &now
+	case now.After(renewAt):
+		s.State = StateRenewable
+		s.NextAt = &now
+	default:
+		s.State = StateValid
+		s.NextAt = &renewAt
+	}
+}
+
+// readLeafCert parses the first certificate of a PEM file.
+func readLeafCert(path string) (*x509.Certificate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in '%s'", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,193 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+)
+
+// clockSkew tolerates a cert whose validity starts slightly in the future
+// relative to the local clock.
+const clockSkew = 5 * time.Minute
+
+// verifyDownloadedCert checks the cert downloaded for conf before it is
+// deployed: it must match the private key at keyPath, be valid now, carry
+// conf.CommonName and chain up to a trusted root through the CA bundle. It
+// returns the full chain PEM with the leaf first and the bundle in order.
+func verifyDownloadedCert(conf *config.CertConf, certPem, caBundlePem, keyPath string) (string, error) {
+	leafs, err := parseCertsPem(certPem)
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate: %w", err)
+	}
+	if len(leafs) != 1 {
+		return "", fmt.Errorf("expected a single certificate, got %d", len(leafs))
+	}
+	leaf := leafs[0]
+	bundle, err := parseCertsPem(caBundlePem)
+	if err != nil {
+		return "", fmt.Errorf("invalid CA bundle: %w", err)
+	}
+
+	if err = checkKeyMatches(leaf, keyPath); err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(conf.CommonName); ip != nil {
+		if !slices.ContainsFunc(leaf.IPAddresses, ip.Equal) {
+			return "", fmt.Errorf("certificate IP SANs %v don't include '%s'", leaf.IPAddresses, conf.CommonName)
+		}
+	} else if err = leaf.VerifyHostname(conf.CommonName); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if now.Add(clockSkew).Before(leaf.NotBefore) {
+		return "", fmt.Errorf("certificate is not valid before %v", leaf.NotBefore)
+	}
+	if !now.Before(leaf.NotAfter) {
+		return "", fmt.Errorf("certificate expired at %v", leaf.NotAfter)
+	}
+
+	chain, err := orderChain(leaf, bundle)
+	if err != nil {
+		return "", err
+	}
+	roots, err := trustedRoots()
+	if err != nil {
+		return "", err
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now.Add(clockSkew),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return "", fmt.Errorf("certificate chain verification failed: %w", err)
+	}
+
+	var fullChain bytes.Buffer
+	for _, c := range chain {
+		if err = pem.Encode(&fullChain, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return "", err
+		}
+	}
+	return fullChain.String(), nil
+}
+
+func parseCertsPem(content string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(content)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certs, nil
+}
+
+// checkKeyMatches makes sure the cert was issued for the private key at
+// keyPath.
+func checkKeyMatches(leaf *x509.Certificate, keyPath string) error {
+	key, err := readPrivateKey(keyPath)
+	if err != nil {
+		return err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T", key)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return fmt.Errorf("certificate public key doesn't match the private key")
+	}
+	return nil
+}
+
+func readPrivateKey(path string) (crypto.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found in '%s'", path)
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported private key block '%s' in '%s'", block.Type, path)
+}
+
+// orderChain sorts the bundle from the issuer of leaf upwards, dropping
+// certs that aren't part of the chain.
+func orderChain(leaf *x509.Certificate, bundle []*x509.Certificate) ([]*x509.Certificate, error) {
+	chain := []*x509.Certificate{leaf}
+	remaining := slices.Clone(bundle)
+	current := leaf
+	for len(remaining) > 0 {
+		i := slices.IndexFunc(remaining, func(c *x509.Certificate) bool {
+			return bytes.Equal(current.RawIssuer, c.RawSubject) && current.CheckSignatureFrom(c) == nil
+		})
+		if i < 0 {
+			break
+		}
+		current = remaining[i]
+		if bytes.Equal(current.RawIssuer, current.RawSubject) {
+			// self-signed root, it comes from the trust store
+			break
+		}
+		chain = append(chain, current)
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+	if len(chain) == 1 && len(bundle) > 0 {
+		return nil, fmt.Errorf("CA bundle doesn't contain the issuer of the certificate")
+	}
+	return chain, nil
+}
+
+// trustedRoots reads the configured trust store, falling back to the system
+// roots when none is set.
+func trustedRoots() (*x509.CertPool, error) {
+	path := config.GetConfig().TrustStore
+	if path == "" {
+		return x509.SystemCertPool()
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in trust store '%s'", path)
+	}
+	return pool, nil
+}
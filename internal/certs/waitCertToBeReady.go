@@ -4,40 +4,38 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/alexkhomych/zerossl-ip-cert/internal/accounts"
 	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
-	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
-	"github.com/alexkhomych/zerossl-ip-cert/internal/utils"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
 	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
 )
 
-func WaitCertToBeReady(client *zerosslIPCert.Client, certID string) error {
+func WaitCertToBeReady(logger *log.Logger, account *accounts.Account, certID string) error {
 	cfg := config.GetConfig()
 	maxWaitTime := time.Duration(cfg.MaxWaitTime) * time.Minute
 	checkInterval := time.Duration(cfg.CheckInterval) * time.Second
 	startTime := time.Now()
 
 	for {
-		var certInfo zerosslIPCert.CertificateInfoModel
-		err := utils.RetryOperationWithConfig(func() error {
-			var err error
-			certInfo, err = client.GetCert(certID)
-			return err
-		})
+		certInfo, err := account.GetCert(certID)
 		if err != nil {
-			log.Info(fmt.Sprintf("get cert error after retries: %v", err))
-			metrics.ApiErrors.Inc()
+			logger.Error("get cert error after retries", "error", err.Error())
 			return err
 		}
 		if certInfo.Status == zerosslIPCert.CertStatus.Issued {
-			log.Info(fmt.Sprintf("cert is ready: %+v", certInfo))
+			logger.Info("cert is ready", "expires", certInfo.Expires)
 			return nil
 		} else {
-			log.Info("awaiting cert to be ready", "status", certInfo.Status)
+			logger.Info("awaiting cert to be ready", "status", certInfo.Status)
 		}
 		if time.Since(startTime) > maxWaitTime {
 			return fmt.Errorf("timeout of waiting cert to be ready")
 		}
-		time.Sleep(checkInterval)
+		select {
+		case <-run.Context().Done():
+			return fmt.Errorf("stopped waiting for cert to be ready: %w", run.Context().Err())
+		case <-time.After(checkInterval):
+		}
 	}
 }
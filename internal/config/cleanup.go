@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Modes of cleanUnfinished.
+const (
+	CleanupNone       CleanupMode = "none"
+	CleanupOwn        CleanupMode = "own"
+	CleanupCommonName CleanupMode = "commonName"
+	CleanupAccount    CleanupMode = "account"
+)
+
+// CleanupMode selects the unfinished certs cancelled before a new cert is
+// created: none, own drafts of the cert config tracked in the data file,
+// those plus any unfinished cert for the same common name, or every
+// unfinished cert of the account. In YAML true stands for own and false
+// for none.
+type CleanupMode string
+
+func (m *CleanupMode) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode && value.Tag == "!!bool" {
+		var b bool
+		if err := value.Decode(&b); err != nil {
+			return err
+		}
+		*m = CleanupNone
+		if b {
+			*m = CleanupOwn
+		}
+		return nil
+	}
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	switch CleanupMode(s) {
+	case "", CleanupNone, CleanupOwn, CleanupCommonName, CleanupAccount:
+		*m = CleanupMode(s)
+		return nil
+	}
+	return fmt.Errorf("line %d: invalid cleanUnfinished '%s'", value.Line, s)
+}
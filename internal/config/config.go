@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
 	"gopkg.in/yaml.v3"
@@ -19,50 +20,173 @@ var (
 )
 
 type Config struct {
-	DataDir          string     `yaml:"dataDir"`
-	LogFile          string     `yaml:"logFile"`
-	CleanUnfinished  bool       `yaml:"cleanUnfinished"`
-	MetricsPort      int        `yaml:"metricsPort"`
-	MaxWaitTime      int        `yaml:"maxWaitTime"`
-	CheckInterval    int        `yaml:"checkInterval"`
-	RetryMaxAttempts int        `yaml:"retryMaxAttempts"`
-	RetryWaitTime    int        `yaml:"retryWaitTime"`
-	CertConfigs      []CertConf `yaml:"certConfigs"`
+	DataDir          string                 `yaml:"dataDir"`
+	ArchiveKeep      *int                   `yaml:"archiveKeep"`
+	LogFile          string                 `yaml:"logFile"`
+	LogLevel         string                 `yaml:"logLevel"`
+	LogFormat        string                 `yaml:"logFormat"`
+	LogSource        bool                   `yaml:"logSource"`
+	LogMaxSize       int                    `yaml:"logMaxSize"`
+	LogMaxAge        int                    `yaml:"logMaxAge"`
+	LogMaxBackups    int                    `yaml:"logMaxBackups"`
+	CleanUnfinished  CleanupMode            `yaml:"cleanUnfinished"`
+	MetricsPort      int                    `yaml:"metricsPort"`
+	MaxWaitTime      int                    `yaml:"maxWaitTime"`
+	CheckInterval    int                    `yaml:"checkInterval"`
+	RetryMaxAttempts int                    `yaml:"retryMaxAttempts"`
+	RetryWaitTime    int                    `yaml:"retryWaitTime"`
+	RetryMultiplier  float64                `yaml:"retryMultiplier"`
+	RetryMaxBackoff  int                    `yaml:"retryMaxBackoff"`
+	RetryJitter      bool                   `yaml:"retryJitter"`
+	RetryDeadline    int                    `yaml:"retryDeadline"`
+	RetryOperations  map[string]RetryConf   `yaml:"retryOperations"`
+	BreakerThreshold int                    `yaml:"breakerThreshold"`
+	BreakerCoolDown  int                    `yaml:"breakerCoolDown"`
+	BreakerUrgent    int                    `yaml:"breakerUrgent"`
+	DaemonInterval   int                    `yaml:"daemonInterval"`
+	TrustStore       string                 `yaml:"trustStore"`
+	MetricLabels     []string               `yaml:"metricLabels"`
+	OrphanPolicy     OrphanPolicy           `yaml:"orphanPolicy"`
+	OrphanAccount    string                 `yaml:"orphanAccount"`
+	HookTimeout      int                    `yaml:"hookTimeout"`
+	HookEnv          []string               `yaml:"hookEnv"`
+	ApiListen        string                 `yaml:"apiListen"`
+	ApiToken         string                 `yaml:"apiToken"`
+	ApiTlsCert       string                 `yaml:"apiTlsCert"`
+	ApiTlsKey        string                 `yaml:"apiTlsKey"`
+	ApiClientCa      string                 `yaml:"apiClientCa"`
+	ApiAllowInsecure bool                   `yaml:"apiAllowInsecure"`
+	Webhooks         []WebhookConf          `yaml:"webhooks"`
+	Smtp             *SmtpConf              `yaml:"smtp"`
+	Accounts         map[string]AccountConf `yaml:"accounts"`
+	CertConfigs      []CertConf             `yaml:"certConfigs"`
+}
+
+type WebhookConf struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Preset  string            `yaml:"preset"`
+	Body    string            `yaml:"body"`
+	Events  []string          `yaml:"events"`
+	Retries int               `yaml:"retries"`
+}
+
+type SmtpConf struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Security string   `yaml:"security"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Events   []string `yaml:"events"`
+	Digest   bool     `yaml:"digest"`
+}
+
+type PreflightConf struct {
+	Enabled       bool   `yaml:"enabled"`
+	Address       string `yaml:"address"`
+	SourceAddress string `yaml:"sourceAddress"`
+	Timeout       int    `yaml:"timeout"`
+	RetryWaitTime int    `yaml:"retryWaitTime"`
+}
+
+type ProbeConf struct {
+	Address       string `yaml:"address"`
+	Timeout       int    `yaml:"timeout"`
+	Retries       int    `yaml:"retries"`
+	RetryWaitTime int    `yaml:"retryWaitTime"`
+}
+
+// RetryConf overrides the global retry settings for an operation, zero
+// fields keep the global value.
+type RetryConf struct {
+	MaxAttempts int     `yaml:"maxAttempts"`
+	WaitTime    int     `yaml:"waitTime"`
+	Multiplier  float64 `yaml:"multiplier"`
+	MaxBackoff  int     `yaml:"maxBackoff"`
+	Jitter      *bool   `yaml:"jitter"`
+	Deadline    int     `yaml:"deadline"`
+}
+
+// AccountConf is a ZeroSSL account. Exactly one of ApiKey, ApiKeyFile and
+// ApiKeyEnv provides the key. ApiUrl is the API base URL, the ZeroSSL API
+// when empty.
+type AccountConf struct {
+	ApiKey     string `yaml:"apiKey"`
+	ApiKeyFile string `yaml:"apiKeyFile"`
+	ApiKeyEnv  string `yaml:"apiKeyEnv"`
+	ApiUrl     string `yaml:"apiUrl"`
+	RateLimit  int    `yaml:"rateLimit"`
+	Quota      int    `yaml:"quota"`
 }
 
 type CertConf struct {
-	ConfID           string `yaml:"confId"`
-	ApiKey           string `yaml:"apiKey"`
-	Country          string `yaml:"country"`
-	Province         string `yaml:"province"`
-	City             string `yaml:"city"`
-	Locality         string `yaml:"locality"`
-	Organization     string `yaml:"organization"`
-	OrganizationUnit string `yaml:"organizationUnit"`
-	CommonName       string `yaml:"commonName"`
-	Days             int    `yaml:"days"`
-	KeyType          string `yaml:"keyType"`
-	KeyBits          int    `yaml:"keyBits"`
-	KeyCurve         string `yaml:"keyCurve"`
-	SigAlg           string `yaml:"sigAlg"`
-	StrictDomains    int    `yaml:"strictDomains"`
-	VerifyMethod     string `yaml:"verifyMethod"`
-	VerifyHook       string `yaml:"verifyHook"`
-	PostHook         string `yaml:"postHook"`
-	CertFile         string `yaml:"certFile"`
-	KeyFile          string `yaml:"keyFile"`
+	ConfID           string            `yaml:"confId"`
+	Labels           map[string]string `yaml:"labels"`
+	Account          string            `yaml:"account"`
+	ApiKey           string            `yaml:"apiKey"`
+	Country          string            `yaml:"country"`
+	Province         string            `yaml:"province"`
+	City             string            `yaml:"city"`
+	Locality         string            `yaml:"locality"`
+	Organization     string            `yaml:"organization"`
+	OrganizationUnit string            `yaml:"organizationUnit"`
+	CommonName       string            `yaml:"commonName"`
+	Days             int               `yaml:"days"`
+	KeyType          string            `yaml:"keyType"`
+	KeyBits          int               `yaml:"keyBits"`
+	KeyCurve         string            `yaml:"keyCurve"`
+	SigAlg           string            `yaml:"sigAlg"`
+	StrictDomains    int               `yaml:"strictDomains"`
+	VerifyMethod     string            `yaml:"verifyMethod"`
+	PreHook          HookConf          `yaml:"preHook"`
+	VerifyHook       HookConf          `yaml:"verifyHook"`
+	VerifyWebroot    string            `yaml:"verifyWebroot"`
+	Preflight        *PreflightConf    `yaml:"preflight"`
+	Probe            *ProbeConf        `yaml:"probe"`
+	PostHook         HookConf          `yaml:"postHook"`
+	FailureHook      HookConf          `yaml:"failureHook"`
+	CertFile         string            `yaml:"certFile"`
+	KeyFile          string            `yaml:"keyFile"`
 }
 
 type Data struct {
 	Certs []CertData `yaml:"certs"`
+	// Drafts are the certs created at ZeroSSL that aren't issued yet.
+	Drafts []DraftData `yaml:"drafts,omitempty"`
+	// Revoked are the conf IDs whose cert was revoked on demand. They get
+	// no new cert until a reissue is forced.
+	Revoked []string `yaml:"revoked,omitempty"`
+	// RolledBack are the last certs rolled back because they weren't
+	// served, by conf ID. Their config gets no new cert for a while.
+	RolledBack []RollbackData `yaml:"rolledBack,omitempty"`
 }
 
-type CertData struct {
-	CommonName string `yaml:"commonName"`
+type RollbackData struct {
+	ConfID string    `yaml:"confId"`
+	CertID string    `yaml:"certId"`
+	Time   time.Time `yaml:"time"`
+}
+
+type DraftData struct {
 	ConfID     string `yaml:"confId"`
+	CommonName string `yaml:"commonName"`
 	CertID     string `yaml:"certId"`
-	CertFile   string `yaml:"certFile"`
-	KeyFile    string `yaml:"keyFile"`
+	Account    string `yaml:"account"`
+}
+
+type CertData struct {
+	CommonName      string `yaml:"commonName"`
+	ConfID          string `yaml:"confId"`
+	CertID          string `yaml:"certId"`
+	Serial          string `yaml:"serial,omitempty"`
+	ConfFingerprint string `yaml:"confFingerprint,omitempty"`
+	Account         string `yaml:"account,omitempty"`
+	CertFile        string `yaml:"certFile"`
+	KeyFile         string `yaml:"keyFile"`
 }
 
 func GetConfig() *Config {
@@ -72,9 +196,19 @@ func GetConfig() *Config {
 			panic(err)
 		}
 
+		if globalConfig.LogLevel == "" {
+			globalConfig.LogLevel = "info"
+		}
+		if globalConfig.LogFormat == "" {
+			globalConfig.LogFormat = "json"
+		}
 		if globalConfig.MetricsPort == 0 {
 			globalConfig.MetricsPort = 2112
 		}
+		if globalConfig.ArchiveKeep == nil {
+			archiveKeep := 3
+			globalConfig.ArchiveKeep = &archiveKeep
+		}
 		if globalConfig.MaxWaitTime == 0 {
 			globalConfig.MaxWaitTime = 180
 		}
@@ -87,6 +221,63 @@ func GetConfig() *Config {
 		if globalConfig.RetryWaitTime == 0 {
 			globalConfig.RetryWaitTime = 15
 		}
+		if globalConfig.RetryMultiplier == 0 {
+			globalConfig.RetryMultiplier = 2
+		}
+		if globalConfig.RetryMaxBackoff == 0 {
+			globalConfig.RetryMaxBackoff = 300
+		}
+		if _, ok := globalConfig.RetryOperations["create_cert"]; !ok {
+			// creating a cert isn't idempotent, a retry after a lost
+			// response would leave a duplicate draft behind
+			if globalConfig.RetryOperations == nil {
+				globalConfig.RetryOperations = map[string]RetryConf{}
+			}
+			globalConfig.RetryOperations["create_cert"] = RetryConf{MaxAttempts: 1}
+		}
+		if globalConfig.BreakerThreshold == 0 {
+			globalConfig.BreakerThreshold = 5
+		}
+		if globalConfig.BreakerCoolDown == 0 {
+			globalConfig.BreakerCoolDown = 300
+		}
+		if globalConfig.BreakerUrgent == 0 {
+			globalConfig.BreakerUrgent = 7
+		}
+		if globalConfig.DaemonInterval == 0 {
+			globalConfig.DaemonInterval = 720
+		}
+		if globalConfig.OrphanPolicy == "" {
+			globalConfig.OrphanPolicy = OrphanKeep
+		}
+		if globalConfig.HookTimeout == 0 {
+			globalConfig.HookTimeout = 300
+		}
+		for _, c := range globalConfig.CertConfigs {
+			if c.Preflight == nil {
+				continue
+			}
+			if c.Preflight.Timeout == 0 {
+				c.Preflight.Timeout = 10
+			}
+			if c.Preflight.RetryWaitTime == 0 {
+				c.Preflight.RetryWaitTime = 5
+			}
+		}
+		for _, c := range globalConfig.CertConfigs {
+			if c.Probe == nil {
+				continue
+			}
+			if c.Probe.Timeout == 0 {
+				c.Probe.Timeout = 10
+			}
+			if c.Probe.RetryWaitTime == 0 {
+				c.Probe.RetryWaitTime = 5
+			}
+		}
+		if globalConfig.HookEnv == nil {
+			globalConfig.HookEnv = []string{"PATH", "HOME", "LANG", "TZ"}
+		}
 	}
 	isGlobalConfigSet = true
 	return globalConfig
@@ -110,13 +301,35 @@ func ReadConfig(path string, config *Config) error {
 
 func GetData() *Data {
 	if !isGlobalDataSet {
-		globalDataFilePath = filepath.Join(globalConfig.DataDir, "/current.yaml")
+		globalDataFilePath = DataFilePath()
 		ReadData(globalDataFilePath)
 	}
 	isGlobalDataSet = true
 	return globalData
 }
 
+// DataFilePath returns the path of the file holding issued certs data.
+func DataFilePath() string {
+	return filepath.Join(GetConfig().DataDir, "/current.yaml")
+}
+
+// LoadData reads the data file at path without touching the global data, so
+// it is safe to call while certs are being issued.
+func LoadData(path string) (*Data, error) {
+	data := &Data{}
+	if !file.PathExists(path) {
+		return data, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(content, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 func ReadData(path string) error {
 	if !file.PathExists(path) {
 		globalData = &Data{}
@@ -144,3 +357,40 @@ func WriteData(data *Data) error {
 	}
 	return nil
 }
+
+// RetryFor returns the retry settings of operation, the global ones with its
+// overrides applied.
+func (c *Config) RetryFor(operation string) RetryConf {
+	jitter := c.RetryJitter
+	conf := RetryConf{
+		MaxAttempts: c.RetryMaxAttempts,
+		WaitTime:    c.RetryWaitTime,
+		Multiplier:  c.RetryMultiplier,
+		MaxBackoff:  c.RetryMaxBackoff,
+		Jitter:      &jitter,
+		Deadline:    c.RetryDeadline,
+	}
+	o, ok := c.RetryOperations[operation]
+	if !ok {
+		return conf
+	}
+	if o.MaxAttempts != 0 {
+		conf.MaxAttempts = o.MaxAttempts
+	}
+	if o.WaitTime != 0 {
+		conf.WaitTime = o.WaitTime
+	}
+	if o.Multiplier != 0 {
+		conf.Multiplier = o.Multiplier
+	}
+	if o.MaxBackoff != 0 {
+		conf.MaxBackoff = o.MaxBackoff
+	}
+	if o.Jitter != nil {
+		conf.Jitter = o.Jitter
+	}
+	if o.Deadline != 0 {
+		conf.Deadline = o.Deadline
+	}
+	return conf
+}
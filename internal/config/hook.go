@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HookConf configures an external hook. In YAML it is either the path of
+// an executable or a mapping with the fields below.
+type HookConf struct {
+	// Path is the executable, run with Args.
+	Path string   `yaml:"path"`
+	Args []string `yaml:"args"`
+	// Command is a shell command run with /bin/sh -c, used instead of Path.
+	Command string `yaml:"command"`
+	// Timeout in seconds, hookTimeout when zero.
+	Timeout int `yaml:"timeout"`
+	// Env lists environment variables passed through to the hook in
+	// addition to hookEnv, "*" passes the whole environment.
+	Env []string `yaml:"env"`
+	// Dir is the working directory.
+	Dir string `yaml:"dir"`
+	// SkipCleanup stops running a verify hook again with
+	// ZEROSSL_HOOK_PHASE=cleanup after validation, for verify hooks written
+	// before the phase existed that would publish the validation file again.
+	SkipCleanup bool `yaml:"skipCleanup"`
+}
+
+// IsSet reports whether a hook is configured.
+func (h HookConf) IsSet() bool {
+	return h.Path != "" || h.Command != ""
+}
+
+// String describes the hook for logs and errors.
+func (h HookConf) String() string {
+	if h.Command != "" {
+		return h.Command
+	}
+	return h.Path
+}
+
+func (h *HookConf) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*h = HookConf{Path: value.Value}
+		return nil
+	}
+	type plain HookConf
+	if err := value.Decode((*plain)(h)); err != nil {
+		return err
+	}
+	if h.Path != "" && h.Command != "" {
+		return fmt.Errorf("line %d: hook can't set both path and command", value.Line)
+	}
+	return nil
+}
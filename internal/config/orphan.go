@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policies of orphanPolicy.
+const (
+	OrphanKeep           OrphanPolicy = "keep"
+	OrphanPrune          OrphanPolicy = "prune"
+	OrphanRevokeAndPrune OrphanPolicy = "revoke-and-prune"
+	OrphanCancel         OrphanPolicy = "cancel"
+)
+
+// OrphanPolicy tells what happens to certs left in the data file after
+// their config was removed: keep them, prune them from the data file,
+// revoke them and remove their files too, or cancel them. ZeroSSL only
+// cancels certs not issued yet, issued ones are only pruned.
+type OrphanPolicy string
+
+// Valid reports whether p is a known policy.
+func (p OrphanPolicy) Valid() bool {
+	switch p {
+	case OrphanKeep, OrphanPrune, OrphanRevokeAndPrune, OrphanCancel:
+		return true
+	}
+	return false
+}
+
+func (p *OrphanPolicy) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	if s != "" && !OrphanPolicy(s).Valid() {
+		return fmt.Errorf("line %d: invalid orphanPolicy '%s'", value.Line, s)
+	}
+	*p = OrphanPolicy(s)
+	return nil
+}
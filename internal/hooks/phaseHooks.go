@@ -0,0 +1,44 @@
+package hooks
+
+import (
+	"fmt"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// RunPreHook runs the pre hook, if configured, before a cert is created,
+// e.g. to open the firewall for validation.
+func RunPreHook(logger *log.Logger, certConf *config.CertConf) error {
+	if !certConf.PreHook.IsSet() {
+		return nil
+	}
+	env := phaseEnv(certConf, "pre")
+	logger.Info("running pre hook")
+	return runHook(logger, "pre", certConf.PreHook, env, nil)
+}
+
+// RunFailureHook runs the failure hook, if configured, with the error that
+// made issuing or renewing the cert fail.
+func RunFailureHook(logger *log.Logger, certConf *config.CertConf, cause error) error {
+	if !certConf.FailureHook.IsSet() {
+		return nil
+	}
+	env := phaseEnv(certConf, "failure")
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_ERROR", cause.Error()))
+	logger.Info("running failure hook")
+	return runHook(logger, "failure", certConf.FailureHook, env, nil)
+}
+
+func phaseEnv(certConf *config.CertConf, phase string) []string {
+	var env []string
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_HOOK_PHASE", phase))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_CONF_ID", certConf.ConfID))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_COMMON_NAME", certConf.CommonName))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_CERT_FPATH", certConf.CertFile))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_KEY_FPATH", certConf.KeyFile))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_RUN_ID", run.ID()))
+	env = append(env, labelEnv(certConf.Labels)...)
+	return env
+}
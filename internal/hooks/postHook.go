@@ -1,25 +1,62 @@
 package hooks
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
+	"time"
 
 	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
-	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
 )
 
-func RunPostHook(certConf *config.CertConf) error {
-	if !file.PathExists(certConf.PostHook) {
-		return fmt.Errorf("post hook executable %v doesn't exist", certConf.PostHook)
+// PostHookInfo describes the deployed cert to the post hook. It is passed
+// as ZEROSSL_* variables and as a JSON document on stdin.
+type PostHookInfo struct {
+	Event             string            `json:"event"`
+	ConfID            string            `json:"confId"`
+	CommonName        string            `json:"commonName"`
+	CertID            string            `json:"certId"`
+	CertFile          string            `json:"certFile"`
+	KeyFile           string            `json:"keyFile"`
+	Serial            string            `json:"serial"`
+	Issuer            string            `json:"issuer"`
+	NotBefore         time.Time         `json:"notBefore"`
+	NotAfter          time.Time         `json:"notAfter"`
+	FingerprintSHA256 string            `json:"fingerprintSha256"`
+	FingerprintSHA1   string            `json:"fingerprintSha1"`
+	PrevCertID        string            `json:"prevCertId,omitempty"`
+	PrevCertFile      string            `json:"prevCertFile,omitempty"`
+	RunID             string            `json:"runId"`
+	Labels            map[string]string `json:"labels,omitempty"`
+}
+
+func RunPostHook(logger *log.Logger, certConf *config.CertConf, info *PostHookInfo) error {
+	if !certConf.PostHook.IsSet() {
+		return fmt.Errorf("post hook is not configured")
 	}
-	cmd := exec.Command(certConf.PostHook)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stdout
-	cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", "ZEROSSL_CERT_FPATH", certConf.CertFile))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", "ZEROSSL_KEY_FPATH", certConf.KeyFile))
-	if err := cmd.Run(); err != nil {
+	info.RunID = run.ID()
+	stdin, err := json.Marshal(info)
+	if err != nil {
 		return err
 	}
-	return nil
+	var env []string
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_CERT_FPATH", certConf.CertFile))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_KEY_FPATH", certConf.KeyFile))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_RUN_ID", info.RunID))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_EVENT", info.Event))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_CONF_ID", info.ConfID))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_COMMON_NAME", info.CommonName))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_CERT_ID", info.CertID))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_SERIAL", info.Serial))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_NOT_BEFORE", info.NotBefore.Format(time.RFC3339)))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_NOT_AFTER", info.NotAfter.Format(time.RFC3339)))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_FINGERPRINT_SHA256", info.FingerprintSHA256))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_FINGERPRINT_SHA1", info.FingerprintSHA1))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_PREV_CERT_ID", info.PrevCertID))
+	env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_PREV_CERT_FPATH", info.PrevCertFile))
+	env = append(env, labelEnv(info.Labels)...)
+	logger.Info("running post hook", "event", info.Event)
+	return runHook(logger, "post", certConf.PostHook, env, bytes.NewReader(stdin))
 }
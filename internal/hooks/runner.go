@@ -0,0 +1,155 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/metrics"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// labelEnv exports the labels of a cert config as ZEROSSL_LABEL_<KEY>, the
+// key upper cased with characters not allowed in names replaced by '_'.
+func labelEnv(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		name := strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' {
+				return r - 'a' + 'A'
+			}
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return '_'
+		}, k)
+		env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_LABEL_"+name, labels[k]))
+	}
+	return env
+}
+
+// runHook runs hook with env added to its passed through environment,
+// logging its output line by line. name identifies the hook in logs and
+// metrics.
+func runHook(logger *log.Logger, name string, hook config.HookConf, env []string, stdin io.Reader) error {
+	cfg := config.GetConfig()
+	timeout := time.Duration(hook.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = time.Duration(cfg.HookTimeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if hook.Command != "" {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", hook.Command)
+	} else {
+		if !file.PathExists(hook.Path) {
+			return fmt.Errorf("%s hook executable %v doesn't exist", name, hook.Path)
+		}
+		cmd = exec.CommandContext(ctx, hook.Path, hook.Args...)
+	}
+	cmd.Dir = hook.Dir
+	cmd.Env = append(passthroughEnv(append(cfg.HookEnv, hook.Env...)), env...)
+	cmd.Stdin = stdin
+	// run the hook in its own process group so a timeout kills its
+	// children too, and don't let a leftover child block us forever
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	hookLogger := logger.With("hook", name, "hook_cmd", hook.String())
+	stdout := &lineLogger{logger: hookLogger, stream: "stdout"}
+	stderr := &lineLogger{logger: hookLogger, stream: "stderr"}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	hookLogger.Info("running hook")
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		metrics.HookRuns.WithLabelValues(name, "-1").Inc()
+		return fmt.Errorf("failed to start %s hook: %w", name, err)
+	}
+	err := cmd.Wait()
+	duration := time.Since(start)
+	stdout.flush()
+	stderr.flush()
+
+	exitCode := cmd.ProcessState.ExitCode()
+	metrics.HookDuration.WithLabelValues(name).Observe(duration.Seconds())
+	metrics.HookRuns.WithLabelValues(name, strconv.Itoa(exitCode)).Inc()
+	hookLogger.Info("hook finished", "exit_code", exitCode, "duration", duration)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s hook timed out after %v", name, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
+
+// lineLogger is an [io.Writer] logging every line written to it.
+type lineLogger struct {
+	logger *log.Logger
+	stream string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(string(l.buf[:i]))
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs a trailing line without a newline.
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		l.log(string(l.buf))
+		l.buf = nil
+	}
+}
+
+func (l *lineLogger) log(line string) {
+	l.logger.Info("hook output", "stream", l.stream, "line", strings.TrimRight(line, "\r"))
+}
+
+// passthroughEnv returns the variables of the current environment listed in
+// names, all of them if names contains "*".
+func passthroughEnv(names []string) []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		for _, name := range names {
+			if name == "*" || name == key {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return env
+}
@@ -3,24 +3,42 @@ package hooks
 import (
 	"fmt"
 	"net/url"
-	"os"
-	"os/exec"
 	"strings"
 
-	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
 	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
 )
 
-func RunVerifyHook(executable string, cerInfo *zerosslIPCert.CertificateInfoModel) error {
-	if !file.PathExists(executable) {
-		return fmt.Errorf("verify hook executable %v not exists", executable)
+// Verify hook phases, passed as ZEROSSL_HOOK_PHASE.
+const (
+	PhaseDeploy  = "deploy"
+	PhaseCleanup = "cleanup"
+)
+
+// RunVerifyHook runs the verify hook in the given phase: deploy publishes
+// the validation file, cleanup removes it once validation is over. labels
+// are those of the cert config. In the cleanup phase cerInfo is nil when no
+// cert was created, the hook then gets no validation details.
+func RunVerifyHook(logger *log.Logger, hook config.HookConf, labels map[string]string,
+	cerInfo *zerosslIPCert.CertificateInfoModel, phase string) error {
+	if !hook.IsSet() {
+		return fmt.Errorf("verify hook is not configured")
+	}
+	if cerInfo == nil {
+		var env []string
+		env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_HOOK_PHASE", phase))
+		env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_RUN_ID", run.ID()))
+		env = append(env, labelEnv(labels)...)
+		logger.Info("running verify hook without a cert", "phase", phase)
+		return runHook(logger, "verify-"+phase, hook, env, nil)
 	}
 	for k, v := range cerInfo.Validation.OtherMethods {
 		if k == cerInfo.CommonName {
 			validateHttpUrl, err := url.Parse(v.FileValidationUrlHttp)
 			if err != nil {
-				log.Error("url parse error", "error", err.Error())
+				logger.Error("url parse error", "url", v.FileValidationUrlHttp, "error", err.Error())
 				return err
 			}
 			host := validateHttpUrl.Host
@@ -30,17 +48,20 @@ func RunVerifyHook(executable string, cerInfo *zerosslIPCert.CertificateInfoMode
 				port = "80"
 			}
 			content := strings.Join(v.FileValidationContent, "\n")
-			cmd := exec.Command(executable)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stdout
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", "ZEROSSL_HTTP_FV_HOST", host))
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", "ZEROSSL_HTTP_FV_PATH", path))
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", "ZEROSSL_HTTP_FV_PORT", port))
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", "ZEROSSL_HTTP_FV_CONTENT", content))
-			if err = cmd.Run(); err != nil {
-				return err
+			var env []string
+			env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_HOOK_PHASE", phase))
+			env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_HTTP_FV_HOST", host))
+			env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_HTTP_FV_PATH", path))
+			env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_HTTP_FV_PORT", port))
+			env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_HTTP_FV_CONTENT", content))
+			env = append(env, fmt.Sprintf("%v=%v", "ZEROSSL_RUN_ID", run.ID()))
+			env = append(env, labelEnv(labels)...)
+			logger.Info("running verify hook", "phase", phase, "host", host, "path", path, "port", port)
+			name := "verify"
+			if phase != PhaseDeploy {
+				name = "verify-" + phase
 			}
-			return err
+			return runHook(logger, name, hook, env, nil)
 		}
 	}
 	return nil
@@ -0,0 +1,160 @@
+package hooks
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/file"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+	zerosslIPCert "github.com/tinkernels/zerossl-ip-cert"
+)
+
+// RunVerify prepares (deploy phase) or tears down (cleanup phase) the HTTP
+// validation of a cert, writing the validation file under verifyWebroot
+// and running the verify hook, whichever are configured. The verify hook
+// runs in the cleanup phase unless it opted out. certInfo is nil when
+// cleaning up after no cert was created.
+//
+// The validation tokens are masked in logs from the deploy phase until the
+// end of the cleanup phase.
+func RunVerify(logger *log.Logger, certConf *config.CertConf, certInfo *zerosslIPCert.CertificateInfoModel, phase string) error {
+	if phase == PhaseCleanup {
+		if certInfo != nil {
+			defer log.RemoveSecrets(validationContent(certInfo)...)
+		}
+		if certConf.VerifyHook.IsSet() && !certConf.VerifyHook.SkipCleanup {
+			if err := RunVerifyHook(logger, certConf.VerifyHook, certConf.Labels, certInfo, phase); err != nil {
+				return err
+			}
+		}
+		if certConf.VerifyWebroot != "" && certInfo != nil {
+			return removeWebrootFile(logger, certConf.VerifyWebroot, certInfo)
+		}
+		return nil
+	}
+	if certConf.VerifyWebroot == "" && !certConf.VerifyHook.IsSet() {
+		return fmt.Errorf("neither verify hook nor verify webroot is configured")
+	}
+	log.AddSecrets(validationContent(certInfo)...)
+	if certConf.VerifyWebroot != "" {
+		if err := writeWebrootFile(logger, certConf.VerifyWebroot, certInfo); err != nil {
+			return err
+		}
+	}
+	if certConf.VerifyHook.IsSet() {
+		return RunVerifyHook(logger, certConf.VerifyHook, certConf.Labels, certInfo, phase)
+	}
+	return nil
+}
+
+// validationContent returns the content of the validation file of the cert.
+func validationContent(certInfo *zerosslIPCert.CertificateInfoModel) []string {
+	return certInfo.Validation.OtherMethods[certInfo.CommonName].FileValidationContent
+}
+
+// webrootFilePath maps the validation URL path of the cert into webroot.
+func webrootFilePath(webroot string, certInfo *zerosslIPCert.CertificateInfoModel) (string, []string, error) {
+	v, ok := certInfo.Validation.OtherMethods[certInfo.CommonName]
+	if !ok {
+		return "", nil, fmt.Errorf("no validation details for '%s'", certInfo.CommonName)
+	}
+	urlPath, err := validationUrlPath(v.FileValidationUrlHttp)
+	if err != nil {
+		return "", nil, err
+	}
+	root := filepath.Clean(webroot)
+	path := filepath.Join(root, filepath.FromSlash(urlPath))
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", nil, fmt.Errorf("validation path '%s' escapes webroot '%s'", urlPath, webroot)
+	}
+	return path, v.FileValidationContent, nil
+}
+
+func writeWebrootFile(logger *log.Logger, webroot string, certInfo *zerosslIPCert.CertificateInfoModel) error {
+	path, content, err := webrootFilePath(webroot, certInfo)
+	if err != nil {
+		return err
+	}
+	created, err := mkdirs(filepath.Dir(path))
+	createdDirsMu.Lock()
+	createdDirs[path] = created
+	createdDirsMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to create validation directory: %w", err)
+	}
+	if err = os.WriteFile(path, []byte(strings.Join(content, "\n")), 0o644); err != nil {
+		return fmt.Errorf("failed to write validation file: %w", err)
+	}
+	// WriteFile doesn't change the mode of an existing file, nor escape the umask
+	if err = os.Chmod(path, 0o644); err != nil {
+		return fmt.Errorf("failed to set validation file mode: %w", err)
+	}
+	logger.Info("validation file written", "file", path)
+	return nil
+}
+
+// removeWebrootFile removes the validation file and the directories created
+// for it, when they are left empty. Directories that existed before are
+// kept.
+func removeWebrootFile(logger *log.Logger, webroot string, certInfo *zerosslIPCert.CertificateInfoModel) error {
+	path, _, err := webrootFilePath(webroot, certInfo)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove validation file: %w", err)
+	}
+	createdDirsMu.Lock()
+	created := createdDirs[path]
+	delete(createdDirs, path)
+	createdDirsMu.Unlock()
+	for _, dir := range created {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	logger.Info("validation file removed", "file", path)
+	return nil
+}
+
+// createdDirs maps validation files to the directories created for them,
+// deepest first.
+var (
+	createdDirsMu sync.Mutex
+	createdDirs   = map[string][]string{}
+)
+
+// mkdirs is like os.MkdirAll, also returning the directories it created,
+// deepest first.
+func mkdirs(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; !file.PathExists(d); d = filepath.Dir(d) {
+		missing = append(missing, d)
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0o755); err != nil && !os.IsExist(err) {
+			return missing[i+1:], err
+		}
+	}
+	return missing, nil
+}
+
+// validationUrlPath returns the path of a validation URL.
+func validationUrlPath(rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if u.Path == "" || u.Path == "/" {
+		return "", fmt.Errorf("validation url '%s' has no path", rawUrl)
+	}
+	return u.Path, nil
+}
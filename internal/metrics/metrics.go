@@ -1,24 +1,104 @@
 package metrics
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Per-cert metrics, labelled with conf_id and the allowed cert labels. They
+// are created by [Init].
+var (
+	CertsIssued   *prometheus.CounterVec
+	CertsRenewed  *prometheus.CounterVec
+	DriftDetected *prometheus.CounterVec
+)
+
+// certLabels maps the allowed cert label keys to their metric label names.
+var certLabels = map[string]string{}
 
 var (
-	CertsIssued = prometheus.NewCounter(prometheus.CounterOpts{
+	ApiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_errors_total",
+		Help: "Total number of ZeroSSL API errors by operation and error class",
+	}, []string{"operation", "class"})
+	ApiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_requests_total",
+		Help: "Total number of ZeroSSL API requests by account",
+	}, []string{"account"})
+	HookDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hook_duration_seconds",
+		Help:    "Duration of hook runs",
+		Buckets: []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300},
+	}, []string{"hook"})
+	HookRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hook_runs_total",
+		Help: "Total number of hook runs by exit code, -1 when the hook didn't start or was killed",
+	}, []string{"hook", "exit_code"})
+	CircuitBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "State of the ZeroSSL API circuit breaker by account, 0 closed, 1 open, 2 half-open",
+	}, []string{"account"})
+	RunInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "run_info",
+		Help: "Identifier of the current run, always 1",
+	}, []string{"run_id"})
+)
+
+// Init creates the per-cert metrics and registers all metrics. allowedLabels
+// are the cert label keys added to per-cert metrics as label_<key>, other
+// labels are left out to bound cardinality.
+func Init(allowedLabels []string) {
+	names := []string{"conf_id"}
+	for _, k := range allowedLabels {
+		name := labelName(k)
+		if slices.Contains(names, name) {
+			continue
+		}
+		certLabels[k] = name
+		names = append(names, name)
+	}
+	CertsIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "certs_issued_total",
 		Help: "Total number of certificates issued",
-	})
-	CertsRenewed = prometheus.NewCounter(prometheus.CounterOpts{
+	}, names)
+	CertsRenewed = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "certs_renewed_total",
 		Help: "Total number of certificates renewed",
-	})
-	ApiErrors = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "api_errors_total",
-		Help: "Total number of API errors",
-	})
-)
+	}, names)
+	DriftDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "drift_detected_total",
+		Help: "Total number of deployed certs found out of sync with the data file, by kind",
+	}, append(names, "kind"))
 
-func Init() {
 	prometheus.MustRegister(CertsIssued)
 	prometheus.MustRegister(CertsRenewed)
 	prometheus.MustRegister(ApiErrors)
+	prometheus.MustRegister(ApiRequests)
+	prometheus.MustRegister(CircuitBreakerState)
+	prometheus.MustRegister(RunInfo)
+	prometheus.MustRegister(DriftDetected)
+	prometheus.MustRegister(HookDuration)
+	prometheus.MustRegister(HookRuns)
+}
+
+// CertLabels returns the per-cert metric labels of a cert config. Allowed
+// labels the config doesn't have are set empty.
+func CertLabels(confID string, labels map[string]string) prometheus.Labels {
+	l := prometheus.Labels{"conf_id": confID}
+	for k, name := range certLabels {
+		l[name] = labels[k]
+	}
+	return l
+}
+
+// labelName turns a cert label key into a valid metric label name.
+func labelName(key string) string {
+	return "label_" + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
 }
@@ -0,0 +1,241 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// Event types.
+const (
+	EventIssued   = "issued"
+	EventRenewed  = "renewed"
+	EventSkipped  = "skipped"
+	EventFailed   = "failed"
+	EventExpiring = "expiring"
+	EventDrift    = "drift"
+)
+
+var eventTypes = []string{EventIssued, EventRenewed, EventSkipped, EventFailed, EventExpiring, EventDrift}
+
+// optInEvents happen on every run, so they are only delivered to notifiers
+// listing them explicitly.
+var optInEvents = []string{EventSkipped}
+
+// Event describes something that happened to a managed cert.
+type Event struct {
+	Type       string            `json:"type"`
+	ConfID     string            `json:"confId"`
+	CommonName string            `json:"commonName"`
+	CertID     string            `json:"certId,omitempty"`
+	NotAfter   *time.Time        `json:"notAfter,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	RunID      string            `json:"runId"`
+	Time       time.Time         `json:"time"`
+}
+
+// Summary is a one-line human readable description of the event.
+func (e Event) Summary() string {
+	s := fmt.Sprintf("Certificate %s for %s (conf %s)", e.Type, e.CommonName, e.ConfID)
+	if e.NotAfter != nil {
+		s += fmt.Sprintf(", expires %s", e.NotAfter.Format(time.RFC3339))
+	}
+	if len(e.Labels) > 0 {
+		keys := make([]string, 0, len(e.Labels))
+		for k := range e.Labels {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		var pairs []string
+		for _, k := range keys {
+			pairs = append(pairs, k+"="+e.Labels[k])
+		}
+		s += fmt.Sprintf(" [%s]", strings.Join(pairs, " "))
+	}
+	if e.Error != "" {
+		s += fmt.Sprintf(": %s", e.Error)
+	}
+	return s
+}
+
+// Notifier delivers events to an external system.
+type Notifier interface {
+	Name() string
+	Notify(e Event) error
+}
+
+// DigestEntry summarises a managed cert in a digest.
+type DigestEntry struct {
+	ConfID     string
+	CommonName string
+	CertID     string
+	Status     string
+	NotAfter   *time.Time
+	Error      string
+}
+
+// DigestNotifier is a [Notifier] also delivering periodic digests.
+type DigestNotifier interface {
+	Notifier
+	Digest(entries []DigestEntry) error
+}
+
+type filtered struct {
+	notifier Notifier
+	events   []string
+}
+
+var (
+	notifiers []filtered
+	digesters []DigestNotifier
+	pending   sync.WaitGroup
+)
+
+// credentialHeader tells whether a header carries a credential, whose value
+// must be masked in logs. Other values, such as content types, are left
+// readable.
+func credentialHeader(name string) bool {
+	name = strings.ToLower(name)
+	return name == "authorization" || name == "proxy-authorization" ||
+		strings.HasSuffix(name, "-token") || strings.HasSuffix(name, "-key") || strings.HasSuffix(name, "-secret")
+}
+
+// headerSecrets returns the value of a credential header and, for values
+// like "Bearer <token>", the credential without its scheme.
+func headerSecrets(value string) []string {
+	secrets := []string{value}
+	if _, credential, ok := strings.Cut(strings.TrimSpace(value), " "); ok {
+		secrets = append(secrets, credential)
+	}
+	return secrets
+}
+
+// Init builds the notifiers configured in cfg.
+func Init(cfg *config.Config) error {
+	notifiers = nil
+	digesters = nil
+	for _, w := range cfg.Webhooks {
+		if err := checkEvents(w.Events); err != nil {
+			return fmt.Errorf("webhook '%s': %w", w.Name, err)
+		}
+		n, err := NewWebhook(w)
+		if err != nil {
+			return fmt.Errorf("webhook '%s': %w", w.Name, err)
+		}
+		log.AddSecrets(w.URL)
+		for k, v := range w.Headers {
+			if credentialHeader(k) {
+				log.AddSecrets(headerSecrets(v)...)
+			}
+		}
+		Register(n, w.Events)
+	}
+	if cfg.Smtp != nil {
+		if err := checkEvents(cfg.Smtp.Events); err != nil {
+			return fmt.Errorf("smtp: %w", err)
+		}
+		n, err := NewSmtp(*cfg.Smtp)
+		if err != nil {
+			return fmt.Errorf("smtp: %w", err)
+		}
+		log.AddSecrets(cfg.Smtp.Password)
+		Register(n, cfg.Smtp.Events)
+		if cfg.Smtp.Digest {
+			digesters = append(digesters, n)
+		}
+	}
+	return nil
+}
+
+// Register adds a notifier receiving the given event types, or all of them
+// but the opt-in ones when events is empty.
+func Register(n Notifier, events []string) {
+	notifiers = append(notifiers, filtered{notifier: n, events: events})
+}
+
+func checkEvents(events []string) error {
+	for _, e := range events {
+		if !slices.Contains(eventTypes, e) {
+			return fmt.Errorf("unknown event type '%s'", e)
+		}
+	}
+	return nil
+}
+
+func (f filtered) wants(eventType string) bool {
+	if len(f.events) == 0 {
+		return !slices.Contains(optInEvents, eventType)
+	}
+	return slices.Contains(f.events, eventType)
+}
+
+// Send delivers e to every notifier subscribed to its type in the
+// background. Use [Wait] before exiting to let deliveries finish.
+func Send(e Event) {
+	e.RunID = run.ID()
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, f := range notifiers {
+		if !f.wants(e.Type) {
+			continue
+		}
+		pending.Add(1)
+		go func(n Notifier) {
+			defer pending.Done()
+			if err := n.Notify(e); err != nil {
+				log.Error("failed to send notification", "notifier", n.Name(), "event", e.Type,
+					"conf_id", e.ConfID, "error", err.Error())
+			}
+		}(f.notifier)
+	}
+}
+
+// HasDigest reports whether any notifier wants digests.
+func HasDigest() bool {
+	return len(digesters) > 0
+}
+
+// SendDigest delivers entries to every digest notifier and waits for the
+// deliveries. It fails only when no notifier delivered the digest.
+func SendDigest(entries []DigestEntry) error {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered bool
+		errs      []error
+	)
+	for _, d := range digesters {
+		wg.Add(1)
+		go func(d DigestNotifier) {
+			defer wg.Done()
+			err := d.Digest(entries)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Error("failed to send digest", "notifier", d.Name(), "error", err.Error())
+				errs = append(errs, fmt.Errorf("%s: %w", d.Name(), err))
+				return
+			}
+			delivered = true
+		}(d)
+	}
+	wg.Wait()
+	if delivered {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// Wait blocks until all notifications sent so far are delivered or failed.
+func Wait() {
+	pending.Wait()
+}
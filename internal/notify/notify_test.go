@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCredentialHeader(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Authorization":       true,
+		"proxy-authorization": true,
+		"X-Api-Key":           true,
+		"X-Auth-Token":        true,
+		"X-Webhook-Secret":    true,
+		"Content-Type":        false,
+		"Accept":              false,
+		"X-Keyboard":          false,
+	} {
+		if got := credentialHeader(name); got != want {
+			t.Errorf("credentialHeader(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestHeaderSecrets(t *testing.T) {
+	if got := headerSecrets("Bearer abc123"); !slices.Equal(got, []string{"Bearer abc123", "abc123"}) {
+		t.Errorf("unexpected secrets %q", got)
+	}
+	if got := headerSecrets("abc123"); !slices.Equal(got, []string{"abc123"}) {
+		t.Errorf("unexpected secrets %q", got)
+	}
+}
+
+// digestStub is a [DigestNotifier] failing with err.
+type digestStub struct {
+	name string
+	err  error
+}
+
+func (d digestStub) Name() string                       { return d.name }
+func (d digestStub) Notify(Event) error                 { return d.err }
+func (d digestStub) Digest(entries []DigestEntry) error { return d.err }
+
+func TestSendDigest(t *testing.T) {
+	t.Cleanup(func() { digesters = nil })
+	down := digestStub{name: "down", err: errors.New("connection refused")}
+
+	digesters = []DigestNotifier{down, digestStub{name: "up"}}
+	if err := SendDigest(nil); err != nil {
+		t.Errorf("digest delivered by one notifier reported as failed: %v", err)
+	}
+	digesters = []DigestNotifier{down}
+	if err := SendDigest(nil); err == nil {
+		t.Error("undelivered digest reported as sent")
+	}
+}
@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+)
+
+// SMTP connection security modes.
+const (
+	SmtpStartTLS = "starttls"
+	SmtpTLS      = "tls"
+	SmtpNone     = "none"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// Smtp sends events and digests as plain text mails.
+type Smtp struct {
+	conf config.SmtpConf
+}
+
+// NewSmtp validates conf, defaulting to STARTTLS on port 587.
+func NewSmtp(conf config.SmtpConf) (*Smtp, error) {
+	if conf.Host == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	if conf.From == "" || len(conf.To) == 0 {
+		return nil, fmt.Errorf("from and to are required")
+	}
+	if conf.Security == "" {
+		conf.Security = SmtpStartTLS
+	}
+	switch conf.Security {
+	case SmtpStartTLS, SmtpNone:
+		if conf.Port == 0 {
+			conf.Port = 587
+		}
+	case SmtpTLS:
+		if conf.Port == 0 {
+			conf.Port = 465
+		}
+	default:
+		return nil, fmt.Errorf("unknown security '%s'", conf.Security)
+	}
+	return &Smtp{conf: conf}, nil
+}
+
+func (s *Smtp) Name() string {
+	return "smtp:" + s.conf.Host
+}
+
+func (s *Smtp) Notify(e Event) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n", e.Summary())
+	fmt.Fprintf(&body, "Event:       %s\n", e.Type)
+	fmt.Fprintf(&body, "Conf ID:     %s\n", e.ConfID)
+	fmt.Fprintf(&body, "Common name: %s\n", e.CommonName)
+	if e.CertID != "" {
+		fmt.Fprintf(&body, "Cert ID:     %s\n", e.CertID)
+	}
+	if e.NotAfter != nil {
+		fmt.Fprintf(&body, "Expires:     %s\n", e.NotAfter.Format(time.RFC3339))
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&body, "Error:       %s\n", e.Error)
+	}
+	fmt.Fprintf(&body, "Run ID:      %s\n", e.RunID)
+	subject := fmt.Sprintf("[zerossl-ip-cert] %s: %s", e.Type, e.CommonName)
+	return s.send(subject, body.String())
+}
+
+func (s *Smtp) Digest(entries []DigestEntry) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Managed certificates as of %s\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&body, "%-10s %-40s %-20s %-26s %s\n", "CONF ID", "COMMON NAME", "STATUS", "EXPIRES", "DAYS LEFT")
+	for _, d := range entries {
+		expires, daysLeft := "-", "-"
+		if d.NotAfter != nil {
+			expires = d.NotAfter.Format(time.RFC3339)
+			daysLeft = strconv.Itoa(int(time.Until(*d.NotAfter).Hours() / 24))
+		}
+		fmt.Fprintf(&body, "%-10s %-40s %-20s %-26s %s\n", d.ConfID, d.CommonName, d.Status, expires, daysLeft)
+		if d.Error != "" {
+			fmt.Fprintf(&body, "%-10s error: %s\n", "", d.Error)
+		}
+	}
+	subject := fmt.Sprintf("[zerossl-ip-cert] daily digest: %d certificates", len(entries))
+	return s.send(subject, body.String())
+}
+
+func (s *Smtp) send(subject, body string) error {
+	addr := net.JoinHostPort(s.conf.Host, strconv.Itoa(s.conf.Port))
+	tlsConfig := &tls.Config{ServerName: s.conf.Host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	if s.conf.Security == SmtpTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, s.conf.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.conf.Security == SmtpStartTLS {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls failed: %w", err)
+		}
+	}
+	if s.conf.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.conf.Host)); err != nil {
+			return fmt.Errorf("auth failed: %w", err)
+		}
+	}
+	if err = client.Mail(s.conf.From); err != nil {
+		return err
+	}
+	for _, to := range s.conf.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(s.message(subject, body)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (s *Smtp) message(subject, body string) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.conf.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "X-Zerossl-Run-Id: %s\r\n", run.ID())
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return msg.Bytes()
+}
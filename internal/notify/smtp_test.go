@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+)
+
+// smtpMail is a message received by smtpSink.
+type smtpMail struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpSink is a minimal in-process SMTP server accepting every message.
+type smtpSink struct {
+	ln    net.Listener
+	mails chan smtpMail
+}
+
+func newSmtpSink(t *testing.T) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{ln: ln, mails: make(chan smtpMail, 4)}
+	t.Cleanup(func() { _ = ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpSink) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = fmt.Fprintf(conn, "%s\r\n", line) }
+	var mail smtpMail
+	reply("220 sink ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			reply("250-sink")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			_, plain, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(plain)
+			mail.auth = string(decoded)
+			reply("235 ok")
+		case "MAIL":
+			mail.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			mail.data = data.String()
+			s.mails <- mail
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpSink) receive(t *testing.T) smtpMail {
+	t.Helper()
+	select {
+	case m := <-s.mails:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+	return smtpMail{}
+}
+
+func TestSmtpNotify(t *testing.T) {
+	sink := newSmtpSink(t)
+	n, err := NewSmtp(config.SmtpConf{
+		Host:     "127.0.0.1",
+		Port:     sink.port(),
+		Security: SmtpNone,
+		Username: "certs",
+		Password: "s3cret",
+		From:     "certs@example.com",
+		To:       []string{"ops@example.com", "oncall@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAfter := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	err = n.Notify(Event{
+		Type:       EventFailed,
+		ConfID:     "1",
+		CommonName: "192.0.2.1",
+		CertID:     "abc123",
+		NotAfter:   &notAfter,
+		Error:      "verify_domains failed",
+		RunID:      "run-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := sink.receive(t)
+	if m.auth != "\x00certs\x00s3cret" {
+		t.Errorf("unexpected auth %q", m.auth)
+	}
+	if m.from != "certs@example.com" {
+		t.Errorf("unexpected sender %q", m.from)
+	}
+	if strings.Join(m.to, ",") != "ops@example.com,oncall@example.com" {
+		t.Errorf("unexpected recipients %v", m.to)
+	}
+	for _, want := range []string{
+		"Subject: [zerossl-ip-cert] failed: 192.0.2.1\r\n",
+		"To: ops@example.com, oncall@example.com\r\n",
+		"Cert ID:     abc123\r\n",
+		"Expires:     2026-12-01T00:00:00Z\r\n",
+		"Error:       verify_domains failed\r\n",
+	} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("mail lacks %q:\n%s", want, m.data)
+		}
+	}
+}
+
+func TestSmtpDigest(t *testing.T) {
+	sink := newSmtpSink(t)
+	n, err := NewSmtp(config.SmtpConf{
+		Host:     "127.0.0.1",
+		Port:     sink.port(),
+		Security: SmtpNone,
+		From:     "certs@example.com",
+		To:       []string{"ops@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Digest([]DigestEntry{
+		{ConfID: "1", CommonName: "192.0.2.1", Status: "ok"},
+		{ConfID: "2", CommonName: "192.0.2.2", Status: "failed", Error: "quota reached"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := sink.receive(t)
+	if m.auth != "" {
+		t.Errorf("authenticated without credentials")
+	}
+	if !strings.Contains(m.data, "Subject: [zerossl-ip-cert] daily digest: 2 certificates\r\n") {
+		t.Errorf("unexpected subject:\n%s", m.data)
+	}
+	for _, want := range []string{"192.0.2.1", "192.0.2.2", "error: quota reached"} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("digest lacks %q:\n%s", want, m.data)
+		}
+	}
+}
+
+func TestNewSmtpDefaults(t *testing.T) {
+	for security, port := range map[string]int{"": 587, SmtpStartTLS: 587, SmtpNone: 587, SmtpTLS: 465} {
+		n, err := NewSmtp(config.SmtpConf{Host: "mail", Security: security, From: "a@b", To: []string{"c@d"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.conf.Port != port {
+			t.Errorf("security %q: port %d, want %d", security, n.conf.Port, port)
+		}
+	}
+	if _, err := NewSmtp(config.SmtpConf{Host: "mail", Security: "ssl", From: "a@b", To: []string{"c@d"}}); err == nil {
+		t.Error("unknown security accepted")
+	}
+}
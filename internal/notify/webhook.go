@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/run"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/utils"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// presets are body templates matching the payload shapes of popular chat
+// services' incoming webhooks.
+var presets = map[string]string{
+	"":        `{{ json . }}`,
+	"slack":   `{"text": {{ json .Summary }}}`,
+	"discord": `{"content": {{ json .Summary }}}`,
+	"teams": `{"@type": "MessageCard", "@context": "https://schema.org/extensions", ` +
+		`"summary": {{ json .Summary }}, "themeColor": {{ if eq .Type "failed" }}"D70000"{{ else }}"2EB886"{{ end }}, ` +
+		`"title": {{ json (printf "zerossl-ip-cert: %s" .Type) }}, "text": {{ json .Summary }}}`,
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Webhook sends events as HTTP requests with a templated body.
+type Webhook struct {
+	conf   config.WebhookConf
+	body   *template.Template
+	client *http.Client
+}
+
+// NewWebhook validates conf and parses its body template, falling back to
+// the preset's template when no body is set.
+func NewWebhook(conf config.WebhookConf) (*Webhook, error) {
+	if conf.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if conf.Method == "" {
+		conf.Method = http.MethodPost
+	}
+	if conf.Name == "" {
+		conf.Name = conf.Preset
+	}
+	body := conf.Body
+	if body == "" {
+		preset, ok := presets[strings.ToLower(conf.Preset)]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset '%s'", conf.Preset)
+		}
+		body = preset
+	}
+	tmpl, err := template.New(conf.Name).Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	return &Webhook{
+		conf:   conf,
+		body:   tmpl,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook:" + w.conf.Name
+}
+
+func (w *Webhook) Notify(e Event) error {
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, e); err != nil {
+		return fmt.Errorf("failed to render body: %w", err)
+	}
+	logger := log.With("notifier", w.Name(), "event", e.Type, "conf_id", e.ConfID)
+	policy := utils.RetryPolicy{MaxAttempts: w.conf.Retries + 1, Base: 5 * time.Second, Jitter: true}
+	return policy.Do(run.Context(), logger, func() error {
+		return w.send(body.Bytes())
+	})
+}
+
+func (w *Webhook) send(body []byte) error {
+	req, err := http.NewRequest(w.conf.Method, w.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	rsp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	_, _ = io.Copy(io.Discard, rsp.Body)
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", rsp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package run
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+var (
+	idMu sync.Mutex
+	id   string
+
+	ctxMu sync.Mutex
+	ctx   = context.Background()
+)
+
+// ID returns the identifier of the current run, shared by logs, hooks and
+// metrics to correlate their output. The first call starts a run.
+func ID() string {
+	idMu.Lock()
+	defer idMu.Unlock()
+	if id == "" {
+		id = newID()
+	}
+	return id
+}
+
+// New starts a new run, such as a daemon iteration, and returns its ID.
+func New() string {
+	idMu.Lock()
+	defer idMu.Unlock()
+	id = newID()
+	return id
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Context returns the context operations of the current run wait in. It is
+// cancelled when the daemon shuts down, so pending retries stop waiting.
+func Context() context.Context {
+	ctxMu.Lock()
+	defer ctxMu.Unlock()
+	return ctx
+}
+
+// SetContext sets the context returned by [Context].
+func SetContext(c context.Context) {
+	ctxMu.Lock()
+	defer ctxMu.Unlock()
+	ctx = c
+}
+
+// Stopping reports whether the context of the run was cancelled.
+func Stopping() bool {
+	return Context().Err() != nil
+}
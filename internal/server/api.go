@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/certs"
+	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+type apiResponse struct {
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StartApi starts the management API on cfg.ApiListen in the background.
+// It refuses to start unless a bearer token or client certs are required,
+// and won't accept the token over plain HTTP unless apiAllowInsecure is set.
+func StartApi(cfg *config.Config) error {
+	if cfg.ApiToken == "" && cfg.ApiClientCa == "" {
+		return fmt.Errorf("management API requires apiToken or apiClientCa to be set")
+	}
+	if (cfg.ApiTlsCert == "") != (cfg.ApiTlsKey == "") {
+		return fmt.Errorf("apiTlsCert and apiTlsKey must be set together")
+	}
+	useTLS := cfg.ApiTlsCert != ""
+	if cfg.ApiClientCa != "" && !useTLS {
+		return fmt.Errorf("apiClientCa requires apiTlsCert and apiTlsKey to be set")
+	}
+	if cfg.ApiToken != "" && !useTLS && !cfg.ApiAllowInsecure {
+		return fmt.Errorf("apiToken over plain HTTP requires apiAllowInsecure, or set apiTlsCert and apiTlsKey")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/certs", listCerts)
+	mux.HandleFunc("GET /api/v1/certs/{confId}/pem", certPEM)
+	mux.HandleFunc("POST /api/v1/certs/{confId}/renew", renewCert(false))
+	mux.HandleFunc("POST /api/v1/certs/{confId}/force-reissue", renewCert(true))
+	mux.HandleFunc("POST /api/v1/certs/{confId}/revoke", revokeCert)
+
+	srv := &http.Server{
+		Addr:              cfg.ApiListen,
+		Handler:           requireToken(cfg.ApiToken, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if useTLS {
+		// Load the key pair now, so a bad one fails startup rather than
+		// the serving goroutine.
+		keyPair, err := tls.LoadX509KeyPair(cfg.ApiTlsCert, cfg.ApiTlsKey)
+		if err != nil {
+			return fmt.Errorf("couldn't load apiTlsCert '%s': %w", cfg.ApiTlsCert, err)
+		}
+		srv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{keyPair},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+	if cfg.ApiClientCa != "" {
+		caPem, err := os.ReadFile(cfg.ApiClientCa)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			return fmt.Errorf("no certificates found in '%s'", cfg.ApiClientCa)
+		}
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	ln, err := net.Listen("tcp", cfg.ApiListen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		log.Info("starting management API", "addr", cfg.ApiListen, "tls", useTLS)
+		var err error
+		if useTLS {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("management API stopped", "addr", cfg.ApiListen, "error", err.Error())
+		}
+	}()
+	return nil
+}
+
+// requireToken rejects requests without the bearer token, when one is set.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			log.Warn("unauthorized management API request", "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+			writeJSON(w, http.StatusUnauthorized, apiResponse{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func listCerts(w http.ResponseWriter, r *http.Request) {
+	status(w, r)
+}
+
+func certPEM(w http.ResponseWriter, r *http.Request) {
+	content, err := certs.CertPEM(r.PathValue("confId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = w.Write(content)
+}
+
+// renewCert starts a renewal in the background, since issuing may take as
+// long as maxWaitTime. Its outcome shows up in the status.
+func renewCert(force bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		confID := r.PathValue("confId")
+		if !certs.ConfExists(confID) {
+			writeError(w, fmt.Errorf("no config with conf id '%s': %w", confID, certs.ErrNotFound))
+			return
+		}
+		if !force && certs.Revoked(confID) {
+			writeError(w, fmt.Errorf("cert of conf id '%s' was revoked: %w", confID, certs.ErrRevoked))
+			return
+		}
+		log.Info("renewal requested via management API", "conf_id", confID, "force", force,
+			"remote_addr", r.RemoteAddr)
+		go func() {
+			if err := certs.RenewConf(confID, force); err != nil {
+				log.Error("on-demand renewal failed", "conf_id", confID, "error", err.Error())
+			}
+		}()
+		writeJSON(w, http.StatusAccepted, apiResponse{Status: "accepted"})
+	}
+}
+
+func revokeCert(w http.ResponseWriter, r *http.Request) {
+	confID := r.PathValue("confId")
+	log.Info("revocation requested via management API", "conf_id", confID, "remote_addr", r.RemoteAddr)
+	if err := certs.Revoke(confID); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiResponse{Status: "revoked"})
+}
+
+// writeError responds with a generic message, since internal errors may
+// carry paths or API responses clients shouldn't see. Details are logged.
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, certs.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, apiResponse{Error: "not found"})
+		return
+	}
+	if errors.Is(err, certs.ErrRevoked) {
+		writeJSON(w, http.StatusConflict, apiResponse{Error: "revoked, force a reissue"})
+		return
+	}
+	log.Error("management API request failed", "error", err.Error())
+	writeJSON(w, http.StatusInternalServerError, apiResponse{Error: "internal error"})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/alexkhomych/zerossl-ip-cert/internal/certs"
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+type readiness struct {
+	Ready   bool     `json:"ready"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// RegisterHealth adds the health, readiness and status endpoints to mux.
+// They are served without authentication, so they leave out file paths and
+// error details.
+func RegisterHealth(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+	mux.HandleFunc("/status", status)
+}
+
+// healthz reports the process is alive.
+func healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether the data file is readable and no managed cert is
+// past its expiry. The config is loaded before the server starts.
+func readyz(w http.ResponseWriter, _ *http.Request) {
+	r := readiness{Ready: true}
+	statuses, err := certs.Status()
+	if err != nil {
+		r.Ready = false
+		log.Error("failed to get certs status", "error", err.Error())
+		r.Reasons = append(r.Reasons, "data file is not readable")
+	}
+	for _, s := range statuses {
+		if s.State == certs.StateExpired {
+			r.Ready = false
+			r.Reasons = append(r.Reasons, fmt.Sprintf("cert for conf %s (%s) is expired", s.ConfID, s.CommonName))
+		}
+	}
+	code := http.StatusOK
+	if !r.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, r)
+}
+
+// status reports the state of every managed cert.
+func status(w http.ResponseWriter, _ *http.Request) {
+	statuses, err := certs.Status()
+	if err != nil {
+		log.Error("failed to get certs status", "error", err.Error())
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	for i := range statuses {
+		statuses[i] = statuses[i].Public()
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("failed to write response", "error", err.Error())
+	}
+}
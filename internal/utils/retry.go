@@ -1,30 +1,128 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"time"
 
 	"github.com/alexkhomych/zerossl-ip-cert/internal/config"
 	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
 )
 
-func RetryOperationWithConfig(operation func() error) error {
-	cfg := config.GetConfig()
-	maxAttempts := cfg.RetryMaxAttempts
-	waitTime := time.Duration(cfg.RetryWaitTime) * time.Second
-	return RetryOperation(operation, maxAttempts, waitTime)
+// RetryPolicy describes how a failing operation is retried, with waits
+// growing exponentially between attempts.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one.
+	MaxAttempts int
+	// Base is the wait after the first failed attempt.
+	Base time.Duration
+	// Multiplier grows the wait after every further attempt, 2 when 0.
+	Multiplier float64
+	// MaxBackoff caps the wait, 0 for no cap.
+	MaxBackoff time.Duration
+	// Jitter waits a random time up to the backoff instead of all of it.
+	Jitter bool
+	// Deadline bounds the time spent on all attempts, 0 for no bound.
+	Deadline time.Duration
+	// Retryable tells errors worth retrying, all but permanent ones when nil.
+	Retryable func(error) bool
 }
 
-func RetryOperation(operation func() error, maxAttempts int, waitTime time.Duration) error {
+// NewRetryPolicy builds a policy from config, see [config.Config.RetryFor].
+func NewRetryPolicy(conf config.RetryConf) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: conf.MaxAttempts,
+		Base:        time.Duration(conf.WaitTime) * time.Second,
+		Multiplier:  conf.Multiplier,
+		MaxBackoff:  time.Duration(conf.MaxBackoff) * time.Second,
+		Jitter:      conf.Jitter != nil && *conf.Jitter,
+		Deadline:    time.Duration(conf.Deadline) * time.Second,
+	}
+}
+
+// Do runs operation until it succeeds, fails with an error that isn't
+// retryable or the policy gives up. A wait hint carried by the error is
+// honoured when longer than the backoff.
+func (p RetryPolicy) Do(ctx context.Context, logger *log.Logger, operation func() error) error {
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+	attempts := max(p.MaxAttempts, 1)
 	var err error
-	for i := 0; i < maxAttempts; i++ {
-		err = operation()
-		if err == nil {
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = operation(); err == nil {
 			return nil
 		}
-		log.Error("operation failed. Retrying...", "error", err.Error(), "wait_time", waitTime)
-		time.Sleep(waitTime)
-		waitTime = waitTime * 2
+		if !p.retryable(err) {
+			logger.Error("operation failed permanently, not retrying", "error", err.Error())
+			return err
+		}
+		if attempt == attempts-1 {
+			break
+		}
+		wait := p.Backoff(attempt)
+		if hint := retryAfter(err); hint > wait {
+			wait = hint
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return fmt.Errorf("operation failed, no time left for attempt %d: %w", attempt+2, err)
+		}
+		logger.Error("operation failed. Retrying...", "error", err.Error(), "attempt", attempt+1,
+			"wait_time", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("operation aborted: %w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+	return fmt.Errorf("operation failed after %d attempts: %w", attempts, err)
+}
+
+// Backoff returns the wait after the failed attempt, counted from 0.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	backoff := float64(p.Base) * math.Pow(multiplier, float64(attempt))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	wait := time.Duration(backoff)
+	if backoff >= math.MaxInt64 {
+		wait = time.Duration(math.MaxInt64)
+	}
+	if p.Jitter && wait > 0 {
+		wait = rand.N(wait)
+	}
+	return wait
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return !IsPermanent(err)
+}
+
+// IsPermanent reports whether err is known not to go away on retry.
+func IsPermanent(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// retryAfter returns the wait err asks for before retrying, if any.
+func retryAfter(err error) time.Duration {
+	var r interface{ RetryAfter() time.Duration }
+	if errors.As(err, &r) {
+		return r.RetryAfter()
 	}
-	return fmt.Errorf("operation failed after %d atempts: %v", maxAttempts, err)
+	return 0
 }
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexkhomych/zerossl-ip-cert/pkg/log"
+)
+
+// testError is an error with the optional hints [RetryPolicy.Do] checks.
+type testError struct {
+	permanent  bool
+	retryAfter time.Duration
+}
+
+func (e testError) Error() string             { return "test error" }
+func (e testError) Permanent() bool           { return e.permanent }
+func (e testError) RetryAfter() time.Duration { return e.retryAfter }
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first wait", RetryPolicy{Base: time.Second}, 0, time.Second},
+		{"default multiplier", RetryPolicy{Base: time.Second}, 3, 8 * time.Second},
+		{"multiplier", RetryPolicy{Base: time.Second, Multiplier: 3}, 2, 9 * time.Second},
+		{"constant", RetryPolicy{Base: time.Second, Multiplier: 1}, 5, time.Second},
+		{"cap", RetryPolicy{Base: time.Second, MaxBackoff: 5 * time.Second}, 4, 5 * time.Second},
+		{"overflow", RetryPolicy{Base: time.Second}, 100, time.Duration(1<<63 - 1)},
+		{"overflow capped", RetryPolicy{Base: time.Second, MaxBackoff: time.Minute}, 100, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Backoff(tt.attempt); got != tt.want {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := RetryPolicy{Base: time.Second, MaxBackoff: 4 * time.Second, Jitter: true}
+	for range 1000 {
+		if got := p.Backoff(5); got < 0 || got >= 4*time.Second {
+			t.Fatalf("jittered backoff %v outside [0, 4s)", got)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	transient := errors.New("transient")
+	tests := []struct {
+		name         string
+		policy       RetryPolicy
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+		minElapsed   time.Duration
+	}{
+		{"success", RetryPolicy{MaxAttempts: 3}, nil, 1, false, 0},
+		{"recovers", RetryPolicy{MaxAttempts: 3, Base: time.Millisecond}, []error{transient, transient}, 3,
+			false, 0},
+		{"gives up", RetryPolicy{MaxAttempts: 3, Base: time.Millisecond},
+			[]error{transient, transient, transient}, 3, true, 0},
+		{"single attempt", RetryPolicy{}, []error{transient}, 1, true, 0},
+		{"permanent", RetryPolicy{MaxAttempts: 3, Base: time.Millisecond}, []error{testError{permanent: true}},
+			1, true, 0},
+		{"retryable override", RetryPolicy{MaxAttempts: 3, Base: time.Millisecond,
+			Retryable: func(err error) bool { return !errors.Is(err, transient) }}, []error{transient}, 1, true, 0},
+		{"retry after hint", RetryPolicy{MaxAttempts: 2, Base: time.Millisecond},
+			[]error{testError{retryAfter: 50 * time.Millisecond}}, 2, false, 50 * time.Millisecond},
+		{"deadline cut-off", RetryPolicy{MaxAttempts: 5, Base: time.Second, Deadline: 100 * time.Millisecond},
+			[]error{transient, transient}, 1, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			start := time.Now()
+			err := tt.policy.Do(context.Background(), log.With(), func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Do() = %v, want error %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minElapsed {
+				t.Errorf("returned after %v, want at least %v", elapsed, tt.minElapsed)
+			}
+		})
+	}
+}
+
+func TestDoCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := RetryPolicy{MaxAttempts: 3, Base: time.Hour}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	err := p.Do(ctx, log.With(), func() error { return errors.New("transient") })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled wait returned after %v", elapsed)
+	}
+}
@@ -1,32 +1,171 @@
 package log
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 )
 
+// Options configures the default logger installed by [Setup].
+type Options struct {
+	// File is the log file path. Logs go to stdout when empty.
+	File string
+	// Level is one of debug, info, warn or error.
+	Level string
+	// Format is either json or text.
+	Format string
+	// Source adds the caller's file and line to each record.
+	Source bool
+	// MaxSize is the size in megabytes after which the log file is rotated.
+	MaxSize int
+	// MaxAge is the number of days rotated log files are kept.
+	MaxAge int
+	// MaxBackups is the number of rotated log files kept.
+	MaxBackups int
+}
+
+var (
+	logFile *RotatingFile
+	// base is the default logger without the attributes set by
+	// [SetDefaultAttrs].
+	base         *slog.Logger
+	defaultAttrs []any
+)
+
+// Setup builds a handler from opts and installs it as the [slog] default.
+func Setup(opts Options) error {
+	var level slog.Level
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return fmt.Errorf("invalid log level '%s': %w", opts.Level, err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if opts.File != "" {
+		f, err := OpenRotatingFile(opts.File, opts.MaxSize, opts.MaxAge, opts.MaxBackups)
+		if err != nil {
+			return err
+		}
+		if logFile != nil {
+			_ = logFile.Close()
+		}
+		logFile = f
+		w = f
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level, AddSource: opts.Source}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	case "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	default:
+		return fmt.Errorf("invalid log format '%s'", opts.Format)
+	}
+	base = slog.New(NewRedactingHandler(handler))
+	slog.SetDefault(base.With(defaultAttrs...))
+	return nil
+}
+
+// Reopen reopens the log file, if any. It is meant to be called after an
+// external tool such as logrotate has moved the file away.
+func Reopen() error {
+	if logFile == nil {
+		return nil
+	}
+	return logFile.Reopen()
+}
+
+// SetDefaultAttrs sets the attributes added to every record logged from now
+// on, replacing those of the previous call.
+func SetDefaultAttrs(args ...any) {
+	if base == nil {
+		base = slog.Default()
+	}
+	defaultAttrs = args
+	slog.SetDefault(base.With(args...))
+}
+
 // Debug calls [slog.Debug].
 func Debug(msg string, args ...any) {
-	slog.Debug(msg, args...)
+	logAt(slog.Default(), slog.LevelDebug, msg, args...)
 }
 
 // Info calls [slog.Info].
 func Info(msg string, args ...any) {
-	slog.Info(msg, args...)
+	logAt(slog.Default(), slog.LevelInfo, msg, args...)
 }
 
 // Warn calls [slog.Warn].
 func Warn(msg string, args ...any) {
-	slog.Warn(msg, args...)
+	logAt(slog.Default(), slog.LevelWarn, msg, args...)
 }
 
 // Error calls [slog.Error].
 func Error(msg string, args ...any) {
-	slog.Error(msg, args...)
+	logAt(slog.Default(), slog.LevelError, msg, args...)
 }
 
 // Fatal calls [slog.Error] and [os.Exit(1)].
 func Fatal(msg string, args ...any) {
-	slog.Error(msg, args...)
+	logAt(slog.Default(), slog.LevelError, msg, args...)
 	os.Exit(1)
 }
+
+// Logger is a scoped logger carrying attributes for a single operation,
+// such as the certificate being worked on.
+type Logger struct {
+	logger *slog.Logger
+}
+
+// With returns a [Logger] based on the default logger with args attached.
+func With(args ...any) *Logger {
+	return &Logger{logger: slog.Default().With(args...)}
+}
+
+// With returns a copy of l with args attached.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{logger: l.logger.With(args...)}
+}
+
+// Debug logs at [slog.LevelDebug].
+func (l *Logger) Debug(msg string, args ...any) {
+	logAt(l.logger, slog.LevelDebug, msg, args...)
+}
+
+// Info logs at [slog.LevelInfo].
+func (l *Logger) Info(msg string, args ...any) {
+	logAt(l.logger, slog.LevelInfo, msg, args...)
+}
+
+// Warn logs at [slog.LevelWarn].
+func (l *Logger) Warn(msg string, args ...any) {
+	logAt(l.logger, slog.LevelWarn, msg, args...)
+}
+
+// Error logs at [slog.LevelError].
+func (l *Logger) Error(msg string, args ...any) {
+	logAt(l.logger, slog.LevelError, msg, args...)
+}
+
+// logAt logs through logger, recording the caller of the exported wrapper
+// as the source rather than this package.
+func logAt(logger *slog.Logger, level slog.Level, msg string, args ...any) {
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	// skip [runtime.Callers, logAt, wrapper]
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.Add(args...)
+	_ = logger.Handler().Handle(ctx, r)
+}
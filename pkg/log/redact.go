@@ -0,0 +1,162 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// minSecretLen guards against masking short values that would make every
+// log line unreadable.
+const minSecretLen = 4
+
+var (
+	privateKeyPattern = regexp.MustCompile(
+		`(?s)-----BEGIN ([A-Z ]*)PRIVATE KEY-----.*?-----END ([A-Z ]*)PRIVATE KEY-----`)
+
+	secretsMu   sync.RWMutex
+	secrets     []string
+	secretsSeen = map[string]bool{}
+)
+
+// AddSecrets registers values that must never appear in log output, such
+// as API keys or validation tokens. Empty and very short values are ignored,
+// as are values already registered.
+func AddSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) < minSecretLen || secretsSeen[v] {
+			continue
+		}
+		secretsSeen[v] = true
+		secrets = append(secrets, v)
+	}
+}
+
+// RemoveSecrets unregisters values added with [AddSecrets] that are no
+// longer in use, such as the validation tokens of a finished validation.
+func RemoveSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if !secretsSeen[v] {
+			continue
+		}
+		delete(secretsSeen, v)
+		secrets = slices.DeleteFunc(secrets, func(s string) bool { return s == v })
+	}
+}
+
+// Redact masks registered secrets and private key PEM blocks in s.
+func Redact(s string) string {
+	s = privateKeyPattern.ReplaceAllString(s, redacted)
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// RedactingHandler is a [slog.Handler] masking secrets in the message and
+// attributes of every record before passing it to the wrapped handler.
+//
+// Attributes and groups bound with WithAttrs and WithGroup are kept and
+// only redacted when a record is handled, so secrets registered after a
+// logger was derived are masked as well.
+type RedactingHandler struct {
+	handler slog.Handler
+	bound   []boundAttrs
+}
+
+// boundAttrs is either a group opened by WithGroup or attributes added
+// by WithAttrs.
+type boundAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewRedactingHandler wraps h.
+func NewRedactingHandler(h slog.Handler) *RedactingHandler {
+	return &RedactingHandler{handler: h}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	nr := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(redactAttr(a))
+		return true
+	})
+	handler := h.handler
+	for _, b := range h.bound {
+		if b.group != "" {
+			handler = handler.WithGroup(b.group)
+			continue
+		}
+		handler = handler.WithAttrs(redactAttrs(b.attrs))
+	}
+	return handler.Handle(ctx, nr)
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(boundAttrs{attrs: attrs})
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(boundAttrs{group: name})
+}
+
+func (h *RedactingHandler) with(b boundAttrs) *RedactingHandler {
+	bound := make([]boundAttrs, len(h.bound), len(h.bound)+1)
+	copy(bound, h.bound)
+	return &RedactingHandler{handler: h.handler, bound: append(bound, b)}
+}
+
+func redactAttrs(attrs []slog.Attr) []slog.Attr {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = redactAttr(a)
+	}
+	return redactedAttrs
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(v.String()))
+	case slog.KindGroup:
+		a.Value = slog.GroupValue(redactAttrs(v.Group())...)
+	case slog.KindAny:
+		// Errors and structs are formatted by the wrapped handler, so check
+		// their text form and only replace the value when it leaks a secret.
+		s := fmt.Sprintf("%+v", v.Any())
+		if rs := Redact(s); rs != s {
+			a.Value = slog.StringValue(rs)
+		} else {
+			a.Value = v
+		}
+	default:
+		a.Value = v
+	}
+	return a
+}
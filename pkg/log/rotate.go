@@ -0,0 +1,147 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102-150405.000"
+
+// RotatingFile is an [io.Writer] appending to a file that is rotated once it
+// grows past a size limit. Rotated files are named after the original with a
+// timestamp suffix and are pruned by count and age.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenRotatingFile opens path for appending. Zero limits disable the
+// corresponding rotation or pruning rule.
+func OpenRotatingFile(path string, maxSizeMB, maxAgeDays, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the file at the same path.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.close(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close closes the underlying file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.close()
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.close(); err != nil {
+		return err
+	}
+	backup := f.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// prune removes rotated files beyond maxBackups or older than maxAge.
+// Failures are ignored, a leftover backup is not worth losing log lines for.
+func (f *RotatingFile) prune() {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, f.path+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	// newest first, the timestamp suffix sorts lexically
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i, b := range backups {
+		if f.maxBackups > 0 && i >= f.maxBackups {
+			_ = os.Remove(b)
+			continue
+		}
+		if f.maxAge > 0 {
+			if info, err := os.Stat(b); err == nil && time.Since(info.ModTime()) > f.maxAge {
+				_ = os.Remove(b)
+			}
+		}
+	}
+}